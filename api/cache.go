@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"claude-copilot/cache"
+)
+
+// CacheHeader reports whether a response was served from the response cache
+const CacheHeader = "X-Copilot-Proxy-Cache"
+
+// cacheRecorder tees everything written to the client so the response can be cached
+type cacheRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *cacheRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *cacheRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *cacheRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// entry converts the recorded response into a cache entry
+func (r *cacheRecorder) entry(stream bool) *cache.Entry {
+	return &cache.Entry{
+		Stream:      stream,
+		ContentType: r.Header().Get("Content-Type"),
+		Body:        bytes.Clone(r.body.Bytes()),
+		CreatedAt:   time.Now(),
+	}
+}
+
+// replayCached writes a cached entry back to the client.
+// Streamed entries are re-sent event by event so the client sees a normal SSE stream.
+func replayCached(w http.ResponseWriter, entry *cache.Entry) {
+	w.Header().Set("Content-Type", entry.ContentType)
+	w.Header().Set(CacheHeader, "HIT")
+
+	flusher, ok := w.(http.Flusher)
+	if !entry.Stream || !ok {
+		w.Write(entry.Body)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	for _, event := range bytes.SplitAfter(entry.Body, []byte("\n\n")) {
+		if len(event) == 0 {
+			continue
+		}
+		w.Write(event)
+		flusher.Flush()
+	}
+}
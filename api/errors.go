@@ -0,0 +1,56 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"claude-copilot/models"
+	"claude-copilot/translator"
+)
+
+// writeError sends an error in the Anthropic API format so clients can parse it
+func writeError(w http.ResponseWriter, status int, errType string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(models.AnthropicError{
+		Type: "error",
+		Error: models.AnthropicErrorDetail{
+			Type:    errType,
+			Message: message,
+		},
+	})
+}
+
+// Retry delays advertised when Copilot does not say when to retry
+const (
+	defaultRateLimitRetry  = 10 * time.Second
+	defaultOverloadedRetry = 5 * time.Second
+)
+
+// setRetryHeaders tells the client when to retry: retry-after, and for rate
+// limits the anthropic-ratelimit-* headers Claude Code backs off with
+func setRetryHeaders(w http.ResponseWriter, retryAfter time.Duration, rateLimited bool) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("retry-after", strconv.Itoa(seconds))
+	if rateLimited {
+		reset := time.Now().Add(time.Duration(seconds) * time.Second).UTC().Format(time.RFC3339)
+		w.Header().Set("anthropic-ratelimit-requests-remaining", "0")
+		w.Header().Set("anthropic-ratelimit-requests-reset", reset)
+	}
+}
+
+// writeUpstreamError answers a failure reported by Copilot with the matching
+// Anthropic error; rate limits and overloads tell the client when to retry
+func writeUpstreamError(w http.ResponseWriter, err *translator.UpstreamError) {
+	switch err.Kind {
+	case translator.KindRateLimit:
+		setRetryHeaders(w, cmp.Or(err.RetryAfter, defaultRateLimitRetry), true)
+	case translator.KindOverloaded:
+		setRetryHeaders(w, cmp.Or(err.RetryAfter, defaultOverloadedRetry), false)
+	}
+	writeError(w, err.Status(), err.AnthropicType(), err.AnthropicMessage())
+}
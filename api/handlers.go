@@ -1,30 +1,132 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"claude-copilot/apikeys"
+	"claude-copilot/audit"
+	"claude-copilot/cache"
 	"claude-copilot/models"
+	"claude-copilot/tracing"
 	"claude-copilot/translator"
+	"claude-copilot/usage"
 
 	copilot "github.com/github/copilot-sdk/go"
 )
 
+// StatusOverloaded is the status Anthropic uses for overloaded_error
+const StatusOverloaded = translator.StatusOverloaded
+
+// ProfileHeader lets a request pick the profile (GitHub account) it is served with
+const ProfileHeader = "X-Copilot-Profile"
+
+// Settings are the handler options that can be changed while the proxy runs
+// (see Handler.UpdateSettings). A Settings value must not be modified once set.
+type Settings struct {
+	Debug         bool
+	DefaultModel  string            // Used when a request omits the model
+	ModelAliases  map[string]string // Requested model name -> Copilot model
+	RequireAPIKey bool              // Checked by Handler.WithAPIKeys
+	Budgets       *usage.Budgets    // Checked against Handler.Usage
+	Retries       int               // Retries of transient Copilot errors before the first token
+}
+
 // Handler wraps the copilot SDK client and provides HTTP endpoints
 type Handler struct {
 	CopilotClient *copilot.Client
-	Debug         bool
+	Cache         *cache.Cache  // Optional response cache (nil = disabled)
+	Limiter       *Limiter      // Optional concurrency limit (nil = unlimited)
+	Audit         *audit.Logger // Optional audit log (nil = disabled)
+	Usage         *usage.Store  // Optional usage accounting (nil = disabled)
+
+	// ProfileClient resolves ProfileHeader to a client (nil = header is rejected)
+	ProfileClient func(name string) (*copilot.Client, error)
+
+	Health HealthInfo // Reported by /healthz and /readyz
+
+	settings atomic.Pointer[Settings]
+
+	draining  atomic.Bool
+	abortOnce sync.Once
+	abortCtx  context.Context // cancelled by Abort
+	abortFn   context.CancelCauseFunc
+}
+
+// Settings returns the current settings
+func (h *Handler) Settings() *Settings {
+	if s := h.settings.Load(); s != nil {
+		return s
+	}
+	return &Settings{}
+}
+
+// UpdateSettings atomically replaces the settings; requests in flight keep the old ones
+func (h *Handler) UpdateSettings(s *Settings) {
+	h.settings.Store(s)
+}
+
+// Drain makes the handler turn away new requests while the server shuts down
+func (h *Handler) Drain() {
+	h.draining.Store(true)
+}
+
+// Abort cancels the requests still in flight: queued requests and non-streaming
+// ones get an overloaded_error, streams end with an error event
+func (h *Handler) Abort() {
+	h.abortContext()
+	h.abortFn(translator.ErrShuttingDown)
+}
+
+func (h *Handler) abortContext() context.Context {
+	h.abortOnce.Do(func() {
+		h.abortCtx, h.abortFn = context.WithCancelCause(context.Background())
+	})
+	return h.abortCtx
+}
+
+// WithAPIKeys wraps next with RequireAPIKey while Settings.RequireAPIKey is on
+func (h *Handler) WithAPIKeys(keys *apikeys.Store, next http.Handler) http.Handler {
+	protected := RequireAPIKey(keys, next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.Settings().RequireAPIKey {
+			protected.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 // HandleMessages processes POST /v1/messages requests from Claude Code
 func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
+	observer := h.observeRequest(w, r, "/v1/messages")
+	defer observer.done()
+	w = observer
+
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if h.draining.Load() {
+		w.Header().Set("Connection", "close")
+		writeError(w, StatusOverloaded, "overloaded_error", "The proxy is shutting down")
+		return
+	}
+
+	// The request ends when the client goes away or the proxy aborts it
+	ctx, cancel := context.WithCancelCause(observer.ctx)
+	defer cancel(nil)
+	stopAbort := context.AfterFunc(h.abortContext(), func() { cancel(context.Cause(h.abortContext())) })
+	defer stopAbort()
+
 	// 1. Decode incoming Anthropic request
 	var anthropicReq models.AnthropicRequest
 	if err := json.NewDecoder(r.Body).Decode(&anthropicReq); err != nil {
@@ -32,17 +134,115 @@ func (h *Handler) HandleMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if h.Debug {
-		log.Println("=== [DEBUG] Incoming Anthropic Request ===")
-		reqJSON, _ := json.MarshalIndent(anthropicReq, "", "  ")
-		fmt.Println(string(reqJSON))
-		log.Println("==========================================")
+	observer.requestedModel = anthropicReq.Model
+	settings := h.Settings()
+	if anthropicReq.Model == "" {
+		anthropicReq.Model = settings.DefaultModel
+	}
+	if target, ok := settings.ModelAliases[anthropicReq.Model]; ok {
+		anthropicReq.Model = target
+	}
+	observer.model, observer.stream = anthropicReq.Model, anthropicReq.Stream
+
+	client := h.CopilotClient
+	if name := r.Header.Get(ProfileHeader); name != "" {
+		observer.profile = name
+		if h.ProfileClient == nil {
+			http.Error(w, "Profile selection is disabled (start the proxy with -profile-header)", http.StatusBadRequest)
+			return
+		}
+		profileClient, err := h.ProfileClient(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to select profile: %v", err), http.StatusBadRequest)
+			return
+		}
+		client = profileClient
+	}
+
+	if settings.Debug {
+		reqJSON, _ := json.Marshal(anthropicReq)
+		slog.DebugContext(ctx, "incoming Anthropic request", "body", json.RawMessage(reqJSON))
+	}
+
+	// 2. Serve from the response cache when possible
+	var cacheKey string
+	if h.Cache != nil {
+		var apiKeyID string
+		if key, ok := apikeys.FromContext(ctx); ok {
+			apiKeyID = key.ID
+		}
+		key, err := cache.Key(&anthropicReq, observer.profile, apiKeyID)
+		if err != nil {
+			slog.WarnContext(ctx, "cache key error", "error", err)
+		} else if entry, ok := h.Cache.Get(key); ok {
+			replayCached(w, entry)
+			return
+		} else {
+			cacheKey = key
+			w.Header().Set(CacheHeader, "MISS")
+		}
+	}
+
+	var out http.ResponseWriter = w
+	var recorder *cacheRecorder
+	if cacheKey != "" {
+		recorder = &cacheRecorder{ResponseWriter: w}
+		out = recorder
+	}
+
+	// 3. Enforce the usage budget of the client (cached responses cost nothing)
+	if !h.checkBudget(ctx, w, settings) {
+		return
+	}
+
+	// 4. Wait for a free slot when the number of concurrent requests is limited
+	if h.Limiter != nil {
+		waitStart := time.Now()
+		_, span := tracing.Start(ctx, "queue_wait", tracing.KindInternal)
+		release, err := h.Limiter.Acquire(ctx)
+		span.SetError(err)
+		span.End()
+		queueWait.Observe(time.Since(waitStart).Seconds())
+		if errors.Is(err, ErrQueueFull) {
+			writeError(w, StatusOverloaded, "overloaded_error", "Too many concurrent requests; try again later")
+			return
+		}
+		if errors.Is(context.Cause(ctx), translator.ErrShuttingDown) {
+			writeError(w, StatusOverloaded, "overloaded_error", "The proxy is shutting down")
+			return
+		}
+		if err != nil {
+			return // client went away while queued
+		}
+		defer release()
 	}
 
-	// 2. Translate and execute via Copilot SDK
-	if err := translator.HandleChatRequest(r.Context(), h.CopilotClient, &anthropicReq, w); err != nil {
-		log.Printf("Error proxying request: %v", err)
+	// 5. Translate and execute via Copilot SDK
+	result, err := translator.HandleChatRequest(ctx, client, &anthropicReq, out, settings.Retries)
+	observer.result, observer.err = result, err
+	if errors.Is(err, translator.ErrShuttingDown) {
+		// Streams have already been ended with an error event
+		if !anthropicReq.Stream {
+			writeError(w, StatusOverloaded, "overloaded_error", "The proxy is shutting down")
+		}
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		return // client went away
+	}
+	var upstream *translator.UpstreamError
+	if errors.As(err, &upstream) {
+		writeUpstreamError(w, upstream) // logged by the translator
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "error proxying request", "error", err)
 		http.Error(w, fmt.Sprintf("Error proxying request: %v", err), http.StatusInternalServerError)
 		return
 	}
+
+	// 6. Only complete, successful responses are cached
+	if recorder != nil && recorder.status == http.StatusOK && result.SessionError == "" {
+		h.Cache.Put(cacheKey, recorder.entry(anthropicReq.Stream))
+	}
 }
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	copilot "github.com/github/copilot-sdk/go"
+)
+
+// healthTimeout bounds the calls to the Copilot CLI made by /readyz
+const healthTimeout = 5 * time.Second
+
+// HealthInfo is what the health endpoints report besides the handler's own state
+type HealthInfo struct {
+	Version     string
+	StartedAt   time.Time
+	TokenExpiry func() time.Time // Copilot token expiry (nil or zero = unknown)
+}
+
+// HealthStatus is the JSON body of /healthz and /readyz
+type HealthStatus struct {
+	Status        string        `json:"status"` // "ok" or "unavailable"
+	Problems      []string      `json:"problems,omitempty"`
+	Version       string        `json:"version"`
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	ShuttingDown  bool          `json:"shutting_down"`
+	CLI           CLIHealth     `json:"cli"`
+	Auth          *AuthHealth   `json:"auth,omitempty"` // readiness only
+	Requests      RequestHealth `json:"requests"`
+}
+
+// CLIHealth describes the Copilot CLI process
+type CLIHealth struct {
+	State  string `json:"state"`
+	PingMs *int64 `json:"ping_ms,omitempty"` // readiness only
+	Error  string `json:"error,omitempty"`
+}
+
+// AuthHealth describes the account the CLI is authenticated as
+type AuthHealth struct {
+	Authenticated  bool       `json:"authenticated"`
+	Login          string     `json:"login,omitempty"`
+	AuthType       string     `json:"auth_type,omitempty"`
+	Message        string     `json:"message,omitempty"`
+	TokenExpiresAt *time.Time `json:"token_expires_at,omitempty"`
+	Error          string     `json:"error,omitempty"`
+}
+
+// RequestHealth counts the requests being served (sessions) and waiting for a slot
+type RequestHealth struct {
+	Active int `json:"active"`
+	Queued int `json:"queued"`
+}
+
+// HandleHealthz serves GET /healthz: liveness. It only fails when the CLI process
+// is gone, and does not call into the CLI.
+func (h *Handler) HandleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := h.baseHealth()
+	if state := h.CopilotClient.State(); state == copilot.StateError || state == copilot.StateDisconnected {
+		status.Problems = append(status.Problems, "Copilot CLI is "+string(state))
+	}
+	writeHealth(w, status)
+}
+
+// HandleReadyz serves GET /readyz: readiness. It fails when requests cannot be
+// served: the CLI does not answer, it is not authenticated or the proxy is stopping.
+func (h *Handler) HandleReadyz(w http.ResponseWriter, r *http.Request) {
+	status := h.baseHealth()
+	if status.ShuttingDown {
+		status.Problems = append(status.Problems, "the proxy is shutting down")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+	defer cancel()
+
+	client := h.CopilotClient
+	if state := client.State(); state != copilot.StateConnected {
+		status.Problems = append(status.Problems, "Copilot CLI is "+string(state))
+	} else {
+		start := time.Now()
+		if _, err := client.Ping(ctx, "readyz"); err != nil {
+			status.CLI.Error = err.Error()
+			status.Problems = append(status.Problems, "Copilot CLI does not answer")
+		} else {
+			ms := time.Since(start).Milliseconds()
+			status.CLI.PingMs = &ms
+		}
+	}
+
+	status.Auth = &AuthHealth{}
+	if h.Health.TokenExpiry != nil {
+		if expiry := h.Health.TokenExpiry(); !expiry.IsZero() {
+			status.Auth.TokenExpiresAt = &expiry
+		}
+	}
+	if status.CLI.PingMs != nil {
+		authStatus, err := client.GetAuthStatus(ctx)
+		switch {
+		case err != nil:
+			status.Auth.Error = err.Error()
+			status.Problems = append(status.Problems, "could not get the auth status")
+		default:
+			status.Auth.Authenticated = authStatus.IsAuthenticated
+			status.Auth.Login = deref(authStatus.Login)
+			status.Auth.AuthType = deref(authStatus.AuthType)
+			status.Auth.Message = deref(authStatus.StatusMessage)
+			if !authStatus.IsAuthenticated {
+				status.Problems = append(status.Problems, "Copilot CLI is not authenticated")
+			}
+		}
+	}
+
+	writeHealth(w, status)
+}
+
+// baseHealth fills in what both endpoints report
+func (h *Handler) baseHealth() *HealthStatus {
+	status := &HealthStatus{
+		Version:      h.Health.Version,
+		ShuttingDown: h.draining.Load(),
+		CLI:          CLIHealth{State: string(h.CopilotClient.State())},
+	}
+	if !h.Health.StartedAt.IsZero() {
+		status.UptimeSeconds = int64(time.Since(h.Health.StartedAt).Seconds())
+	}
+	if h.Limiter != nil {
+		status.Requests.Active, status.Requests.Queued = h.Limiter.Stats()
+	}
+	return status
+}
+
+// writeHealth responds 200 when there are no problems, 503 otherwise
+func writeHealth(w http.ResponseWriter, status *HealthStatus) {
+	code := http.StatusOK
+	status.Status = "ok"
+	if len(status.Problems) > 0 {
+		code = http.StatusServiceUnavailable
+		status.Status = "unavailable"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(status)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"claude-copilot/apikeys"
+)
+
+// RequireAPIKey rejects requests without a valid inbound API key, accepted either as
+// x-api-key (what ANTHROPIC_API_KEY sends) or Authorization: Bearer (ANTHROPIC_AUTH_TOKEN).
+// The matching key is attached to the request context.
+func RequireAPIKey(keys *apikeys.Store, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secret := r.Header.Get("x-api-key")
+		if secret == "" {
+			if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+				secret = strings.TrimSpace(bearer)
+			}
+		}
+
+		if secret == "" {
+			writeError(w, http.StatusUnauthorized, "authentication_error", "missing API key (x-api-key or Authorization: Bearer)")
+			return
+		}
+		key, ok := keys.Verify(secret)
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "authentication_error", "invalid API key")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(apikeys.WithKey(r.Context(), key)))
+	})
+}
+
+// clientName identifies the request's inbound API key by its name (or ID when
+// unnamed) for the audit log and usage accounting; "" without a key
+func clientName(ctx context.Context) string {
+	key, ok := apikeys.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	if key.Name != "" {
+		return key.Name
+	}
+	return key.ID
+}
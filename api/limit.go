@@ -0,0 +1,98 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+)
+
+// ErrQueueFull is returned by Acquire when both the slots and the queue are taken
+var ErrQueueFull = errors.New("too many queued requests")
+
+// Limiter bounds the number of requests served concurrently. Requests over the
+// limit wait in a FIFO queue of bounded length. Limits can be changed at runtime.
+type Limiter struct {
+	mu            sync.Mutex
+	maxConcurrent int // 0 = unlimited
+	maxQueued     int // 0 = unlimited
+	active        int
+	waiters       []chan struct{}
+}
+
+// NewLimiter creates a limiter; zero values mean unlimited
+func NewLimiter(maxConcurrent, maxQueued int) *Limiter {
+	return &Limiter{maxConcurrent: maxConcurrent, maxQueued: maxQueued}
+}
+
+// SetLimits changes the limits, admitting queued requests if there is now room
+func (l *Limiter) SetLimits(maxConcurrent, maxQueued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.maxConcurrent, l.maxQueued = maxConcurrent, maxQueued
+	l.admitLocked()
+}
+
+// Stats returns the number of running and queued requests
+func (l *Limiter) Stats() (active, queued int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.active, len(l.waiters)
+}
+
+// Acquire waits for a slot. The returned function must be called to free it.
+func (l *Limiter) Acquire(ctx context.Context) (release func(), err error) {
+	l.mu.Lock()
+	if l.hasRoomLocked() {
+		l.active++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+	if l.maxQueued > 0 && len(l.waiters) >= l.maxQueued {
+		l.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	ready := make(chan struct{})
+	l.waiters = append(l.waiters, ready)
+	l.mu.Unlock()
+
+	select {
+	case <-ready:
+		return l.release, nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if i := slices.Index(l.waiters, ready); i >= 0 {
+			l.waiters = slices.Delete(l.waiters, i, i+1)
+			return nil, ctx.Err()
+		}
+		// The slot was granted while we gave up; pass it on
+		l.releaseLocked()
+		return nil, ctx.Err()
+	}
+}
+
+func (l *Limiter) hasRoomLocked() bool {
+	return l.maxConcurrent <= 0 || l.active < l.maxConcurrent
+}
+
+func (l *Limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked()
+}
+
+// releaseLocked frees a slot, handing it to the next waiter if allowed
+func (l *Limiter) releaseLocked() {
+	l.active--
+	l.admitLocked()
+}
+
+// admitLocked wakes queued requests in order while there is room
+func (l *Limiter) admitLocked() {
+	for len(l.waiters) > 0 && l.hasRoomLocked() {
+		l.active++
+		close(l.waiters[0])
+		l.waiters = l.waiters[1:]
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"claude-copilot/auth"
+)
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="ja">
+<head>
+<meta charset="utf-8">
+<title>claude-copilot login</title>
+{{if eq .Status "pending" "idle"}}<meta http-equiv="refresh" content="3">{{end}}
+<style>
+body { font-family: sans-serif; max-width: 36em; margin: 4em auto; color: #222; }
+.code { font-family: monospace; font-size: 2.5em; letter-spacing: .1em; padding: .3em .6em; border: 1px solid #ccc; display: inline-block; }
+.status { color: #666; }
+</style>
+</head>
+<body>
+<h1>claude-copilot</h1>
+{{if eq .Status "pending"}}
+<p>1. <a href="{{.VerificationURI}}" target="_blank" rel="noopener">{{.VerificationURI}}</a> を開いてください</p>
+<p>2. 次のコードを入力してください:</p>
+<p class="code">{{.UserCode}}</p>
+<p class="status">認証を待っています…（有効期限 {{.ExpiresAt.Format "15:04:05"}}）</p>
+{{else if eq .Status "authenticated"}}
+<p>✅ GitHub Copilot 認証済み{{if .Login}}（{{.Login}}）{{end}}</p>
+{{else if eq .Status "failed"}}
+<p>❌ 認証に失敗しました: {{.Error}}</p>
+<p class="status">プロキシを再起動して再試行してください。</p>
+{{else}}
+<p class="status">認証を準備しています…</p>
+{{end}}
+</body>
+</html>
+`))
+
+// HandleLogin serves the /login page showing the device code and live authentication status.
+// Clients asking for JSON get the raw status instead.
+func HandleLogin(w http.ResponseWriter, r *http.Request) {
+	state := auth.CurrentLogin()
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") || r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	loginPage.Execute(w, state)
+}
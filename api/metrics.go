@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"claude-copilot/audit"
+	"claude-copilot/logging"
+	"claude-copilot/metrics"
+	"claude-copilot/tracing"
+	"claude-copilot/translator"
+	"claude-copilot/usage"
+)
+
+// statusClientClosed is recorded for requests whose client went away before a
+// response was written (the code nginx uses)
+const statusClientClosed = 499
+
+var (
+	requestsTotal = metrics.NewCounterVec("claude_copilot_requests_total",
+		"Requests by endpoint, model, stream mode and response status.", "endpoint", "model", "stream", "status")
+	requestDuration = metrics.NewHistogramVec("claude_copilot_request_duration_seconds",
+		"Total request latency, including the queue wait.", metrics.DefBuckets, "endpoint", "model", "stream")
+	queueWait = metrics.NewHistogramVec("claude_copilot_queue_wait_seconds",
+		"Time requests waited for a free slot (see -max-concurrent-requests).",
+		[]float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300})
+)
+
+// RegisterMetrics exposes the number of active and queued requests as gauges
+func (h *Handler) RegisterMetrics() {
+	metrics.NewGaugeFunc("claude_copilot_requests_active", "Requests being served.", func() float64 {
+		if h.Limiter == nil {
+			return 0
+		}
+		active, _ := h.Limiter.Stats()
+		return float64(active)
+	})
+	metrics.NewGaugeFunc("claude_copilot_requests_queued", "Requests waiting for a free slot.", func() float64 {
+		if h.Limiter == nil {
+			return 0
+		}
+		_, queued := h.Limiter.Stats()
+		return float64(queued)
+	})
+}
+
+// requestObserver records the metrics and usage of one request, logs it, traces
+// it and writes its audit record once it completes
+type requestObserver struct {
+	http.ResponseWriter
+	r        *http.Request
+	ctx      context.Context // the request context, carrying the request span
+	span     *tracing.Span
+	audit    *audit.Logger
+	usage    *usage.Store
+	endpoint string
+	start    time.Time
+	status   int
+
+	requestedModel string
+	model          string
+	stream         bool
+	profile        string
+	result         *translator.Result
+	err            error
+}
+
+func (h *Handler) observeRequest(w http.ResponseWriter, r *http.Request, endpoint string) *requestObserver {
+	ctx := tracing.WithRemoteParent(r.Context(), r.Header.Get(tracing.TraceparentHeader))
+	ctx, span := tracing.Start(ctx, r.Method+" "+endpoint, tracing.KindServer,
+		tracing.String("http.request.method", r.Method),
+		tracing.String("http.route", endpoint),
+		tracing.String("request_id", logging.RequestID(ctx)))
+	return &requestObserver{ResponseWriter: w, r: r, ctx: ctx, span: span, audit: h.Audit, usage: h.Usage, endpoint: endpoint, start: time.Now()}
+}
+
+func (o *requestObserver) WriteHeader(status int) {
+	if o.status == 0 {
+		o.status = status
+	}
+	o.ResponseWriter.WriteHeader(status)
+}
+
+func (o *requestObserver) Write(p []byte) (int, error) {
+	if o.status == 0 {
+		o.status = http.StatusOK
+	}
+	return o.ResponseWriter.Write(p)
+}
+
+func (o *requestObserver) Flush() {
+	if f, ok := o.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// done records the request; call it when the handler returns
+func (o *requestObserver) done() {
+	status := o.status
+	if status == 0 {
+		status = statusClientClosed
+	}
+	model := translator.ModelLabel(o.model)
+	stream := strconv.FormatBool(o.stream)
+	duration := time.Since(o.start)
+	requestsTotal.Inc(o.endpoint, model, stream, strconv.Itoa(status))
+	requestDuration.Observe(duration.Seconds(), o.endpoint, model, stream)
+	slog.InfoContext(o.ctx, "request completed", "endpoint", o.endpoint, "model", o.model,
+		"stream", o.stream, "status", status, "duration_ms", duration.Milliseconds())
+	o.endSpan(status)
+	o.recordUsage()
+
+	if o.audit != nil {
+		if err := o.audit.Log(o.auditRecord(status, duration)); err != nil {
+			slog.ErrorContext(o.r.Context(), "failed to write the audit log", "error", err)
+		}
+	}
+}
+
+// endSpan ends the request span with the model and token attributes
+func (o *requestObserver) endSpan(status int) {
+	o.span.SetAttributes(
+		tracing.Int("http.response.status_code", status),
+		tracing.String("gen_ai.request.model", o.requestedModel),
+		tracing.Bool("claude_copilot.stream", o.stream))
+	if o.profile != "" {
+		o.span.SetAttributes(tracing.String("claude_copilot.profile", o.profile))
+	}
+	if hit := o.Header().Get(CacheHeader); hit != "" {
+		o.span.SetAttributes(tracing.String("claude_copilot.cache", hit))
+	}
+	model := o.model
+	if res := o.result; res != nil {
+		model = res.Model
+		o.span.SetAttributes(
+			tracing.Int("gen_ai.usage.input_tokens", res.InputTokens),
+			tracing.Int("gen_ai.usage.output_tokens", res.OutputTokens))
+		if res.SessionError != "" {
+			o.span.SetError(errors.New(res.SessionError))
+		}
+	}
+	o.span.SetAttributes(tracing.String("gen_ai.response.model", model))
+	o.span.SetError(o.err)
+	if status >= 500 && o.err == nil {
+		o.span.SetError(errors.New("HTTP " + strconv.Itoa(status)))
+	}
+	o.span.End()
+}
+
+func (o *requestObserver) auditRecord(status int, duration time.Duration) *audit.Record {
+	ctx := o.r.Context()
+	rec := &audit.Record{
+		Time:           o.start,
+		RequestID:      logging.RequestID(ctx),
+		Client:         clientName(ctx),
+		RemoteAddr:     o.r.RemoteAddr,
+		Profile:        o.profile,
+		RequestedModel: o.requestedModel,
+		Model:          o.model,
+		Stream:         o.stream,
+		Status:         status,
+		DurationMs:     duration.Milliseconds(),
+	}
+	if o.err != nil {
+		rec.Error = o.err.Error()
+	}
+	if res := o.result; res != nil {
+		rec.Model = res.Model
+		rec.InputTokens, rec.OutputTokens = res.InputTokens, res.OutputTokens
+		rec.StopReason = res.StopReason
+		rec.Prompt, rec.Completion = res.Prompt, res.Completion
+		if res.SessionError != "" {
+			rec.Error = res.SessionError
+		}
+	}
+	return rec
+}
@@ -0,0 +1,20 @@
+package api
+
+import (
+	"net/http"
+
+	"claude-copilot/logging"
+)
+
+// RequestIDHeader carries the request ID in responses, as in the Anthropic API
+const RequestIDHeader = "request-id"
+
+// WithRequestID gives every request an ID, echoed in RequestIDHeader and attached
+// to the request context so log lines of the request carry it
+func WithRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := logging.NewRequestID()
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"claude-copilot/apikeys"
+	"claude-copilot/usage"
+)
+
+// BudgetWarningHeader carries the soft budgets the client has passed
+const BudgetWarningHeader = "X-Copilot-Budget-Warning"
+
+// checkBudget enforces the usage budget of the request's API key: past a soft
+// limit it adds BudgetWarningHeader, at a hard limit it rejects the request with a
+// rate_limit_error. It reports whether the request may proceed.
+func (h *Handler) checkBudget(ctx context.Context, w http.ResponseWriter, settings *Settings) bool {
+	if h.Usage == nil {
+		return true
+	}
+	var name, id string
+	if key, ok := apikeys.FromContext(ctx); ok {
+		name, id = key.Name, key.ID
+	}
+	verdict := h.Usage.Check(clientName(ctx), settings.Budgets.For(name, id), time.Now())
+	if verdict.Exceeded != "" {
+		slog.WarnContext(ctx, "usage budget exceeded", "client", clientName(ctx), "budget", verdict.Exceeded)
+		setRetryHeaders(w, verdict.RetryAfter, true)
+		writeError(w, http.StatusTooManyRequests, "rate_limit_error", "Usage budget exceeded: "+verdict.Exceeded)
+		return false
+	}
+	if warning := verdict.Warning(); warning != "" {
+		slog.WarnContext(ctx, "usage soft budget passed", "client", clientName(ctx), "budget", warning)
+		w.Header().Set(BudgetWarningHeader, warning)
+	}
+	return true
+}
+
+// recordUsage adds a request served by Copilot to the usage store. Its premium
+// request cost is the one Copilot reported, or else the multiplier of the model
+// (nothing for a failed turn).
+func (o *requestObserver) recordUsage() {
+	res := o.result
+	if o.usage == nil || res == nil {
+		return // no usage store, or the request never reached Copilot
+	}
+	premium := o.usage.Multiplier(res.Model)
+	switch {
+	case res.PremiumRequests != nil:
+		premium = *res.PremiumRequests
+	case res.SessionError != "":
+		premium = 0 // failed without a reported cost, e.g. rate limited
+	}
+	o.usage.Record(o.start, clientName(o.ctx), res.Model, usage.Counters{
+		Requests:        1,
+		PremiumRequests: premium,
+		InputTokens:     int64(res.InputTokens),
+		OutputTokens:    int64(res.OutputTokens),
+	})
+}
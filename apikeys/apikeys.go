@@ -0,0 +1,225 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// keyPrefix marks keys issued by this proxy
+const keyPrefix = "ccp_"
+
+// ErrNotFound is returned by Revoke when no key matches
+var ErrNotFound = errors.New("api key not found")
+
+// Key is an inbound API key. Only the SHA-256 hash of the secret is stored.
+type Key struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hint      string     `json:"hint"` // first characters of the secret, for listing
+	Hash      string     `json:"hash"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Store holds the inbound API keys. It re-reads the key file when it changes on disk,
+// so keys created or revoked by `claude-copilot keys` apply to a running proxy.
+type Store struct {
+	path string
+
+	mu      sync.Mutex
+	keys    []*Key
+	modTime time.Time
+}
+
+// Open loads the key store at path (a missing file is an empty store)
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DefaultPath returns the path to the key file
+func DefaultPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "." // Fallback
+	}
+	return filepath.Join(homeDir, ".claude_copilot_keys.json")
+}
+
+// Create generates a new key and returns its secret. The secret is not stored
+// and cannot be shown again.
+func (s *Store) Create(name string) (string, *Key, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	secret := keyPrefix + base64.RawURLEncoding.EncodeToString(raw)
+
+	id := make([]byte, 4)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+
+	key := &Key{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		Hint:      secret[:len(keyPrefix)+4],
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return "", nil, err
+	}
+	s.keys = append(s.keys, key)
+	if err := s.saveLocked(); err != nil {
+		return "", nil, err
+	}
+	return secret, key, nil
+}
+
+// List returns all keys, including revoked ones
+func (s *Store) List() ([]*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return append([]*Key(nil), s.keys...), nil
+}
+
+// Revoke marks the key with the given ID or name as revoked
+func (s *Store) Revoke(idOrName string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+
+	for _, key := range s.keys {
+		if key.RevokedAt == nil && (key.ID == idOrName || key.Name == idOrName) {
+			now := time.Now()
+			key.RevokedAt = &now
+			return key, s.saveLocked()
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Active reports whether at least one key is usable
+func (s *Store) Active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadLocked()
+	for _, key := range s.keys {
+		if key.RevokedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify returns the active key matching secret
+func (s *Store) Verify(secret string) (*Key, bool) {
+	if secret == "" {
+		return nil, false
+	}
+	hash := hashSecret(secret)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		slog.Warn("APIキーの読み込みに失敗しました", "error", err)
+	}
+
+	for _, key := range s.keys {
+		if key.RevokedAt == nil && subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hash)) == 1 {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+func (s *Store) reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reloadLocked()
+}
+
+// reloadLocked re-reads the key file if it changed since the last read
+func (s *Store) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.keys, s.modTime = nil, time.Time{}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat key file: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse key file: %w", err)
+	}
+	s.keys, s.modTime = keys, info.ModTime()
+	return nil
+}
+
+func (s *Store) saveLocked() error {
+	data, err := json.MarshalIndent(s.keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal keys: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+type contextKey struct{}
+
+// WithKey returns a context carrying the authenticated key
+func WithKey(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the authenticated key of the request, if any
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
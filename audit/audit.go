@@ -0,0 +1,225 @@
+// Package audit writes one JSON line per proxied request to an audit log, with
+// optional (redacted) prompts and completions, rotating the file by size and age.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-copilot/redact"
+)
+
+// Record is one line of the audit log
+type Record struct {
+	Time           time.Time `json:"time"`
+	RequestID      string    `json:"request_id"`
+	Client         string    `json:"client,omitempty"` // inbound API key name (or ID)
+	RemoteAddr     string    `json:"remote_addr,omitempty"`
+	Profile        string    `json:"profile,omitempty"`
+	RequestedModel string    `json:"requested_model,omitempty"`
+	Model          string    `json:"model,omitempty"` // the Copilot model that served the request
+	Stream         bool      `json:"stream"`
+	Status         int       `json:"status"`
+	InputTokens    int       `json:"input_tokens"`
+	OutputTokens   int       `json:"output_tokens"`
+	DurationMs     int64     `json:"duration_ms"`
+	StopReason     string    `json:"stop_reason,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Prompt         string    `json:"prompt,omitempty"`     // only with Options.IncludeContent
+	Completion     string    `json:"completion,omitempty"` // only with Options.IncludeContent
+}
+
+// Options configure a Logger
+type Options struct {
+	Path           string
+	MaxBytes       int64         // rotate when the file would grow past this size (0 = never)
+	MaxAge         time.Duration // rotate when the first record is older than this (0 = never)
+	MaxBackups     int           // rotated files kept (0 = all)
+	IncludeContent bool          // log prompts and completions
+	Redactor       *redact.Redactor
+}
+
+// Logger appends records to the audit log. It is safe for concurrent use.
+type Logger struct {
+	opts Options
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	firstAt time.Time // time of the first record in the current file (zero = empty)
+}
+
+// Open opens (or creates) the audit log for appending
+func Open(opts Options) (*Logger, error) {
+	l := &Logger{opts: opts}
+	if err := l.openLocked(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Path returns the path of the current log file
+func (l *Logger) Path() string {
+	return l.opts.Path
+}
+
+// Log redacts rec and appends it. Prompts and completions are dropped unless
+// Options.IncludeContent is set.
+func (l *Logger) Log(rec *Record) error {
+	r := *rec
+	if !l.opts.IncludeContent {
+		r.Prompt, r.Completion = "", ""
+	}
+	r.Error = l.opts.Redactor.String(r.Error)
+	r.Prompt = l.opts.Redactor.String(r.Prompt)
+	r.Completion = l.opts.Redactor.String(r.Completion)
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var rotateErr error
+	if l.file != nil && l.needsRotationLocked(int64(len(line)), r.Time) {
+		if err := l.rotateLocked(); err != nil {
+			rotateErr = fmt.Errorf("failed to rotate audit log: %w", err)
+		}
+	}
+	if l.file == nil {
+		// Reopening failed after a rotation; try again
+		if err := l.openLocked(); err != nil {
+			return errors.Join(rotateErr, err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if l.firstAt.IsZero() {
+		l.firstAt = r.Time
+	}
+	return errors.Join(rotateErr, err)
+}
+
+// Close closes the log file
+func (l *Logger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+func (l *Logger) openLocked() error {
+	if err := os.MkdirAll(filepath.Dir(l.opts.Path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.opts.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size, l.firstAt = file, info.Size(), firstRecordTime(l.opts.Path)
+	return nil
+}
+
+func (l *Logger) needsRotationLocked(next int64, now time.Time) bool {
+	if l.size == 0 {
+		return false
+	}
+	if l.opts.MaxBytes > 0 && l.size+next > l.opts.MaxBytes {
+		return true
+	}
+	return l.opts.MaxAge > 0 && !l.firstAt.IsZero() && now.Sub(l.firstAt) > l.opts.MaxAge
+}
+
+// backupLayout is the timestamp of rotated files
+const backupLayout = "20060102T150405.000"
+
+// rotateLocked renames the current file to <name>-<timestamp><ext>, removes the
+// oldest rotated files past MaxBackups and starts a new file. When the rename
+// fails the current file is reopened, so logging goes on.
+func (l *Logger) rotateLocked() error {
+	l.file.Close()
+	l.file = nil
+	ext := filepath.Ext(l.opts.Path)
+	base := strings.TrimSuffix(l.opts.Path, ext)
+	rotated := base + "-" + time.Now().UTC().Format(backupLayout) + ext
+	if err := os.Rename(l.opts.Path, rotated); err != nil {
+		return errors.Join(err, l.openLocked())
+	}
+	if l.opts.MaxBackups > 0 {
+		backups := Backups(l.opts.Path)
+		for len(backups) > l.opts.MaxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return l.openLocked()
+}
+
+// Backups returns the rotated files of the log at path, oldest first. Only
+// names with the rotation timestamp count, so other files next to the log
+// (audit-old.jsonl) are never pruned.
+func Backups(path string) []string {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+	matches, _ := filepath.Glob(prefix + "*" + ext)
+	backups := matches[:0]
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ext)
+		if _, err := time.Parse(backupLayout, stamp); err == nil && len(stamp) == len(backupLayout) {
+			backups = append(backups, m)
+		}
+	}
+	slices.Sort(backups) // the timestamps sort chronologically
+	return backups
+}
+
+// firstRecordTime returns the time of the first record of the file at path
+func firstRecordTime(path string) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return time.Time{}
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err != nil {
+		return time.Time{}
+	}
+	var rec Record
+	if json.Unmarshal(line, &rec) != nil {
+		return time.Time{}
+	}
+	return rec.Time
+}
+
+// ReadLast returns the last n lines of the log at path (all of them if n <= 0)
+func ReadLast(path string, n int) ([][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20) // records with prompts can be large
+	for scanner.Scan() {
+		lines = append(lines, slices.Clone(scanner.Bytes()))
+		if n > 0 && len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return lines, scanner.Err()
+}
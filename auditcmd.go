@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"claude-copilot/audit"
+)
+
+// auditPollInterval is how often `audit tail -follow` checks the log for new records
+const auditPollInterval = 500 * time.Millisecond
+
+// runAuditCommand implements `claude-copilot audit tail`
+func runAuditCommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  claude-copilot audit tail [-n N] [-follow] [-json] [-audit-log PATH]")
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	if isHelpArg(args[0]) {
+		usage()
+		return exitOK
+	}
+
+	switch args[0] {
+	case "tail":
+		return runAuditTail(args[1:])
+	default:
+		usage()
+		return exitUsage
+	}
+}
+
+// runAuditTail prints the last records of the audit log, optionally following it
+func runAuditTail(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("audit tail", flag.ContinueOnError))
+	n := f.fs.Int("n", 20, "表示する件数（0 = すべて）")
+	follow := f.fs.Bool("follow", false, "追記される記録を表示し続ける（Ctrl-C で終了）")
+	jsonOutput := f.fs.Bool("json", false, "記録を JSONL のまま出力")
+	if code, ok := parseCommandFlags(f.fs, "audit tail [FLAGS]", args, "n", "follow", "json", "audit-log", "profile"); !ok {
+		return code
+	}
+	if _, err := f.loadConfig(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 設定の読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+	path := *f.auditLog
+	if path == "" {
+		fmt.Fprintln(os.Stderr, "❌ 監査ログが設定されていません（-audit-log または設定ファイルの audit_log）")
+		return exitFailure
+	}
+
+	show := func(line []byte) {
+		if *jsonOutput {
+			fmt.Printf("%s\n", line)
+			return
+		}
+		printAuditRecord(os.Stdout, line)
+	}
+
+	lines, err := audit.ReadLast(path, *n)
+	if err != nil && !(*follow && os.IsNotExist(err)) {
+		fmt.Fprintf(os.Stderr, "❌ 監査ログを読み込めません: %v\n", err)
+		return exitFailure
+	}
+	for _, line := range lines {
+		show(line)
+	}
+	if !*follow {
+		return exitOK
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := followAuditLog(ctx, path, show); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// followAuditLog calls fn for each record appended to the log at path until ctx
+// is done, starting over from the beginning of the new file after a rotation
+func followAuditLog(ctx context.Context, path string, fn func(line []byte)) error {
+	var file *os.File
+	var reader *bufio.Reader
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// Start at the end of the current file: ReadLast has printed the rest
+	if current, err := os.Open(path); err == nil {
+		if _, err := current.Seek(0, io.SeekEnd); err != nil {
+			current.Close()
+			return err
+		}
+		file, reader = current, bufio.NewReader(current)
+	}
+
+	var pending []byte // a partially written line
+	drain := func() {
+		for file != nil {
+			chunk, err := reader.ReadBytes('\n')
+			pending = append(pending, chunk...)
+			if err != nil {
+				return
+			}
+			fn(pending[:len(pending)-1])
+			pending = nil
+		}
+	}
+	for {
+		drain()
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(auditPollInterval):
+		}
+
+		// Reopen when the log was rotated (or created)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if file != nil {
+			if current, err := file.Stat(); err == nil && os.SameFile(info, current) {
+				continue
+			}
+			drain() // records written just before the rotation
+			file.Close()
+		}
+		if file, err = os.Open(path); err != nil {
+			file = nil
+			continue
+		}
+		reader, pending = bufio.NewReader(file), nil
+	}
+}
+
+// printAuditRecord prints one record as a line of text
+func printAuditRecord(w io.Writer, line []byte) {
+	var rec audit.Record
+	if err := json.Unmarshal(line, &rec); err != nil {
+		fmt.Fprintf(w, "%s\n", line)
+		return
+	}
+	client := valueOrDash(rec.Client)
+	model := valueOrDash(rec.Model)
+	if rec.RequestedModel != "" && rec.RequestedModel != rec.Model {
+		model = rec.RequestedModel + "→" + model
+	}
+	outcome := valueOrDash(rec.StopReason)
+	if rec.Error != "" {
+		outcome = "error: " + rec.Error
+	}
+	fmt.Fprintf(w, "%s  %s  %-12s %-28s %3d  in=%-6d out=%-6d %6.1fs  %s\n",
+		rec.Time.Local().Format("2006-01-02 15:04:05"), rec.RequestID, client, model, rec.Status,
+		rec.InputTokens, rec.OutputTokens, float64(rec.DurationMs)/1000, outcome)
+}
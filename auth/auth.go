@@ -2,34 +2,55 @@ package auth
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
 	"time"
 
 	"claude-copilot/config"
+	"claude-copilot/qrcode"
 )
 
 // Insecure controls whether TLS certificate verification is skipped
 // for HTTP requests to GitHub (set by --insecure flag)
 var Insecure bool
 
-// newHTTPClient creates an HTTP client that respects the Insecure setting
+// CACertPath is an extra PEM bundle trusted for HTTP requests to GitHub (set by --ca-cert flag)
+var CACertPath string
+
+// newHTTPClient creates an HTTP client that respects the Insecure and CACertPath
+// settings and the HTTP(S)_PROXY environment variables
 func newHTTPClient() *http.Client {
-	client := &http.Client{Timeout: 10 * time.Second}
-	if Insecure {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	switch {
+	case Insecure:
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	case CACertPath != "":
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
 		}
+		if pem, err := os.ReadFile(CACertPath); err != nil {
+			slog.Warn("CA証明書を読み込めません", "path", CACertPath, "error", err)
+		} else if !pool.AppendCertsFromPEM(pem) {
+			slog.Warn("CA証明書に有効な証明書が含まれていません", "path", CACertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
 	}
-	return client
+
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}
 }
 
 const (
-	clientID      = "Iv1.b507a08c87ecfe98" // Well-known Client ID for GitHub Copilot
-	deviceCodeURL = "https://github.com/login/device/code"
-	tokenURL      = "https://github.com/login/oauth/access_token"
+	clientID = "Iv1.b507a08c87ecfe98" // Well-known Client ID for GitHub Copilot
 )
 
 // DeviceCodeRequest holds the parameters for requesting a device code
@@ -62,14 +83,86 @@ type TokenResponse struct {
 	Error       string `json:"error"`
 }
 
-// EnsureToken checks if a valid token exists; if not, performs the Device Auth flow
-func EnsureToken(cfg *config.AppConfig) error {
-	if cfg.GitHubToken != "" {
-		fmt.Println("✅ Found existing GitHub Copilot token.")
-		// Optionally: Add logic here to validate the token if needed
+// ErrNoToken is returned by EnsureToken when NoDeviceFlow is set and no usable token was found
+var ErrNoToken = errors.New("no usable GitHub token found and the device flow is disabled (--no-device-flow)")
+
+// EnsureToken makes sure a working token exists. Tokens are tried in the order
+// documented on tokenCandidates and validated against GitHub; tokens that were
+// revoked or have no Copilot seat are skipped. If none is usable, the Device Auth
+// flow is started (unless NoDeviceFlow is set). Cancelling ctx (e.g. Ctrl-C) aborts the flow.
+func EnsureToken(ctx context.Context, cfg *config.AppConfig) error {
+	for _, candidate := range tokenCandidates(cfg) {
+		info, err := ValidateToken(ctx, candidate.token)
+		switch {
+		case err == nil:
+			slog.Info("GitHub Copilot のトークンを使用します", "user", info.Login, "source", candidate.source)
+			setLoginState(LoginState{Status: LoginAuthenticated, Login: info.Login})
+		case errors.Is(err, ErrNetwork):
+			// Can't tell whether the token is bad; keep it and let the CLI try
+			slog.Warn("トークンを検証できませんでした（ネットワークエラー）。このトークンでそのまま続行します", "source", candidate.source, "error", err)
+			setLoginState(LoginState{Status: LoginAuthenticated})
+		case errors.Is(err, ErrTokenRevoked):
+			slog.Warn("トークンは失効しています（取り消し済みまたは期限切れ）", "source", candidate.source)
+			continue
+		case errors.Is(err, ErrNoCopilotSubscription):
+			slog.Warn("このアカウントには Copilot サブスクリプションがありません", "source", candidate.source, "error", err)
+			continue
+		default:
+			return fmt.Errorf("failed to validate token from %s: %w", candidate.source, err)
+		}
+
+		if candidate.stored {
+			cfg.GitHubToken = candidate.token
+		} else {
+			// Externally managed tokens are used as-is and never written to our store
+			cfg.UseExternalToken(candidate.token)
+		}
 		return nil
 	}
+	cfg.GitHubToken = ""
+
+	if NoDeviceFlow {
+		setLoginState(LoginState{Status: LoginFailed, Error: ErrNoToken.Error()})
+		return ErrNoToken
+	}
+	fmt.Println("🔑 No usable token found; starting the device flow.")
+	return Login(ctx, cfg)
+}
+
+// Login runs the Device Auth flow unconditionally and stores the new token
+// (used by `claude-copilot login` to replace the current account)
+func Login(ctx context.Context, cfg *config.AppConfig) error {
+	token, err := deviceFlow(ctx)
+	if err != nil {
+		setLoginState(LoginState{Status: LoginFailed, Error: err.Error()})
+		return err
+	}
+
+	// A fresh token can still belong to an account without a seat
+	info, err := ValidateToken(ctx, token)
+	switch {
+	case errors.Is(err, ErrNoCopilotSubscription), errors.Is(err, ErrTokenRevoked):
+		setLoginState(LoginState{Status: LoginFailed, Error: err.Error()})
+		return err
+	case err != nil:
+		slog.Warn("新しいトークンを検証できませんでした", "error", err)
+		setLoginState(LoginState{Status: LoginAuthenticated})
+	default:
+		fmt.Printf("✅ Successfully authenticated! (user: %s)\n", info.Login)
+		setLoginState(LoginState{Status: LoginAuthenticated, Login: info.Login})
+	}
+
+	// Save token
+	cfg.GitHubToken = token
+	if err := config.SaveConfig(cfg); err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
 
+	return nil
+}
+
+// deviceFlow runs the GitHub Device Auth flow and returns the new OAuth token
+func deviceFlow(ctx context.Context) (string, error) {
 	fmt.Println("🚀 Commencing GitHub Device Authentication...")
 
 	// 1. Request Device Code
@@ -78,51 +171,59 @@ func EnsureToken(cfg *config.AppConfig) error {
 		Scope:    "read:user", // Standard scope sufficient for Copilot API access via this ClientID
 	})
 
-	req, _ := http.NewRequest("POST", deviceCodeURL, bytes.NewBuffer(reqBody))
+	req, _ := http.NewRequestWithContext(ctx, "POST", deviceCodeURL(), bytes.NewBuffer(reqBody))
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Content-Type", "application/json")
 
 	client := newHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to request device code: %w", err)
+		return "", fmt.Errorf("failed to request device code: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("device code request failed with status: %s", resp.Status)
+		return "", fmt.Errorf("device code request failed with status: %s", resp.Status)
 	}
 
 	var deviceResp DeviceCodeResponse
 	if err := json.NewDecoder(resp.Body).Decode(&deviceResp); err != nil {
-		return fmt.Errorf("failed to decode device response: %w", err)
+		return "", fmt.Errorf("failed to decode device response: %w", err)
 	}
 
+	setLoginState(LoginState{
+		Status:          LoginPending,
+		UserCode:        deviceResp.UserCode,
+		VerificationURI: deviceResp.VerificationURI,
+		ExpiresAt:       time.Now().Add(time.Duration(deviceResp.ExpiresIn) * time.Second),
+	})
+
 	// Print instructions for the user
 	fmt.Println("\n========================================================")
 	fmt.Printf("1. Open your browser to: %s\n", deviceResp.VerificationURI)
 	fmt.Printf("2. Enter the following code: %s\n", deviceResp.UserCode)
-	fmt.Println("Waiting for authorization...")
-	fmt.Println("========================================================")
-
-	// 2. Poll for Token
-	token, err := pollForToken(deviceResp.DeviceCode, deviceResp.Interval, deviceResp.ExpiresIn)
-	if err != nil {
-		return err
+	if ShowQRCode {
+		if code, err := qrcode.Encode(deviceResp.VerificationURI); err == nil {
+			fmt.Print(code.Terminal())
+		}
 	}
+	if LoginPageURL != "" {
+		fmt.Printf("   (or open %s to see the code and status)\n", LoginPageURL)
+	}
+	fmt.Println("Waiting for authorization... (Ctrl-C to cancel)")
+	fmt.Println("========================================================")
 
-	fmt.Println("✅ Successfully authenticated!")
-
-	// Save token
-	cfg.GitHubToken = token
-	if err := config.SaveConfig(cfg); err != nil {
-		return fmt.Errorf("failed to save token: %w", err)
+	if OpenBrowser {
+		if err := openBrowser(deviceResp.VerificationURI); err != nil {
+			fmt.Printf("⚠️  ブラウザを開けませんでした: %v\n", err)
+		}
 	}
 
-	return nil
+	// 2. Poll for Token
+	return pollForToken(ctx, deviceResp.DeviceCode, deviceResp.Interval, deviceResp.ExpiresIn)
 }
 
-func pollForToken(deviceCode string, interval int, expiresIn int) (string, error) {
+func pollForToken(ctx context.Context, deviceCode string, interval int, expiresIn int) (string, error) {
 	deadline := time.Now().Add(time.Duration(expiresIn) * time.Second)
 	pollInterval := time.Duration(interval) * time.Second
 
@@ -133,6 +234,16 @@ func pollForToken(deviceCode string, interval int, expiresIn int) (string, error
 
 	client := newHTTPClient()
 
+	// wait sleeps for the poll interval unless ctx is cancelled first
+	wait := func() error {
+		select {
+		case <-time.After(pollInterval):
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("authentication cancelled: %w", ctx.Err())
+		}
+	}
+
 	for time.Now().Before(deadline) {
 		reqBody, _ := json.Marshal(TokenRequest{
 			ClientID:   clientID,
@@ -140,21 +251,25 @@ func pollForToken(deviceCode string, interval int, expiresIn int) (string, error
 			GrantType:  "urn:ietf:params:oauth:grant-type:device_code",
 		})
 
-		req, _ := http.NewRequest("POST", tokenURL, bytes.NewBuffer(reqBody))
+		req, _ := http.NewRequestWithContext(ctx, "POST", tokenURL(), bytes.NewBuffer(reqBody))
 		req.Header.Set("Accept", "application/json")
 		req.Header.Set("Content-Type", "application/json")
 
 		resp, err := client.Do(req)
 		if err != nil {
 			// Network error, just retry after interval
-			time.Sleep(pollInterval)
+			if err := wait(); err != nil {
+				return "", err
+			}
 			continue
 		}
 
 		var tokenResp TokenResponse
 		if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
 			resp.Body.Close()
-			time.Sleep(pollInterval)
+			if err := wait(); err != nil {
+				return "", err
+			}
 			continue
 		}
 		resp.Body.Close()
@@ -173,7 +288,9 @@ func pollForToken(deviceCode string, interval int, expiresIn int) (string, error
 			pollInterval += 5 * time.Second
 		}
 
-		time.Sleep(pollInterval)
+		if err := wait(); err != nil {
+			return "", err
+		}
 	}
 
 	return "", fmt.Errorf("authentication timed out")
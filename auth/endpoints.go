@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"strings"
+)
+
+// GitHubHost is the GitHub host used for the device flow and API calls
+// (set by --github-host / github_host). Supports github.com, GHE.com tenants
+// (e.g. "acme.ghe.com") and GitHub Enterprise Server hosts.
+var GitHubHost = "github.com"
+
+// APIBaseURL overrides the REST API base derived from GitHubHost
+// (set by --github-api-url / github_api_url)
+var APIBaseURL string
+
+// apiBase returns the REST API base URL for the configured host
+func apiBase() string {
+	if APIBaseURL != "" {
+		return strings.TrimRight(APIBaseURL, "/")
+	}
+	host := strings.ToLower(GitHubHost)
+	switch {
+	case host == "github.com":
+		return "https://api.github.com"
+	case strings.HasSuffix(host, ".ghe.com"):
+		// GHE.com data-residency tenants use an api. subdomain like github.com
+		return "https://api." + host
+	default:
+		// GitHub Enterprise Server
+		return "https://" + host + "/api/v3"
+	}
+}
+
+func deviceCodeURL() string { return "https://" + GitHubHost + "/login/device/code" }
+
+func tokenURL() string { return "https://" + GitHubHost + "/login/oauth/access_token" }
+
+func githubUserURL() string { return apiBase() + "/user" }
+
+func copilotInternalTokenURL() string { return apiBase() + "/copilot_internal/v2/token" }
+
+// Endpoints returns the base URLs the proxy and the Copilot CLI connect to for
+// the configured host (for connectivity diagnostics)
+func Endpoints() []string {
+	endpoints := []string{"https://" + GitHubHost, apiBase()}
+	host := strings.ToLower(GitHubHost)
+	switch {
+	case host == "github.com":
+		endpoints = append(endpoints, "https://api.githubcopilot.com")
+	case strings.HasSuffix(host, ".ghe.com"):
+		endpoints = append(endpoints, "https://copilot-api."+host)
+	}
+	return endpoints
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// OpenBrowser opens the verification URL with the desktop opener during the device flow (set by --open-browser)
+var OpenBrowser bool
+
+// ShowQRCode prints the verification URL as a terminal QR code during the device flow (set by --qr)
+var ShowQRCode = true
+
+// LoginPageURL is the proxy's /login page, mentioned in the device flow instructions when set
+var LoginPageURL string
+
+// Login status values
+const (
+	LoginIdle          = "idle"
+	LoginPending       = "pending"
+	LoginAuthenticated = "authenticated"
+	LoginFailed        = "failed"
+)
+
+// LoginState is the live status of authentication, shown on the /login page
+type LoginState struct {
+	Status          string    `json:"status"`
+	UserCode        string    `json:"user_code,omitempty"`
+	VerificationURI string    `json:"verification_uri,omitempty"`
+	ExpiresAt       time.Time `json:"expires_at,omitzero"`
+	Login           string    `json:"login,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+var (
+	loginMu    sync.Mutex
+	loginState = LoginState{Status: LoginIdle}
+)
+
+// CurrentLogin returns a snapshot of the authentication status
+func CurrentLogin() LoginState {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	return loginState
+}
+
+func setLoginState(state LoginState) {
+	loginMu.Lock()
+	defer loginMu.Unlock()
+	loginState = state
+}
+
+// openBrowser opens url with the platform's desktop opener
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %w", cmd.Args[0], err)
+	}
+	go cmd.Wait() // reap the opener
+	return nil
+}
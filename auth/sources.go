@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"claude-copilot/config"
+)
+
+// TokenFile is a file containing a GitHub token, tried before any other source (set by --token-file)
+var TokenFile string
+
+// NoDeviceFlow makes EnsureToken fail instead of starting the interactive device flow (set by --no-device-flow)
+var NoDeviceFlow bool
+
+// Environment variables checked for a GitHub token, in order
+var tokenEnvVars = []string{"COPILOT_GITHUB_TOKEN", "GH_TOKEN"}
+
+// tokenCandidate is a token together with a human-readable description of where it came from
+type tokenCandidate struct {
+	source string
+	token  string
+	stored bool // came from our own credential store
+}
+
+// tokenCandidates lists the available tokens in the documented lookup order:
+//
+//  1. --token-file
+//  2. COPILOT_GITHUB_TOKEN, GH_TOKEN
+//  3. the token stored by a previous device flow
+//  4. the gh CLI (hosts.yml, then `gh auth token`)
+//
+// The device flow is the last resort and is handled by EnsureToken. When a
+// profile is selected only its stored token counts: the other sources belong
+// to whatever account the environment is logged in as, not to the profile.
+func tokenCandidates(cfg *config.AppConfig) []tokenCandidate {
+	storedToken := cfg.GitHubToken
+	if cfg.Profile != "" {
+		if storedToken == "" {
+			return nil
+		}
+		return []tokenCandidate{{source: "stored credentials (profile " + cfg.Profile + ")", token: storedToken, stored: true}}
+	}
+
+	var candidates []tokenCandidate
+
+	if TokenFile != "" {
+		data, err := os.ReadFile(TokenFile)
+		if err != nil {
+			slog.Warn("トークンファイルを読み込めません", "path", TokenFile, "error", err)
+		} else if token := strings.TrimSpace(string(data)); token != "" {
+			candidates = append(candidates, tokenCandidate{source: "token file " + TokenFile, token: token})
+		}
+	}
+
+	for _, key := range tokenEnvVars {
+		if token := strings.TrimSpace(os.Getenv(key)); token != "" {
+			candidates = append(candidates, tokenCandidate{source: "environment variable " + key, token: token})
+		}
+	}
+
+	if storedToken != "" {
+		candidates = append(candidates, tokenCandidate{source: "stored credentials", token: storedToken, stored: true})
+	}
+
+	if token, source := ghCLIToken(GitHubHost); token != "" {
+		candidates = append(candidates, tokenCandidate{source: source, token: token})
+	}
+
+	return candidates
+}
+
+// ghCLIToken reads the gh CLI's token for host from its hosts.yml, falling back
+// to `gh auth token` (newer gh versions keep the token in the OS keyring)
+func ghCLIToken(host string) (token string, source string) {
+	path := ghHostsPath()
+	if data, err := os.ReadFile(path); err == nil {
+		if token := parseGHHostsToken(data, host); token != "" {
+			return token, "gh CLI " + path
+		}
+	}
+
+	if _, err := exec.LookPath("gh"); err != nil {
+		return "", ""
+	}
+	out, err := exec.Command("gh", "auth", "token", "--hostname", host).Output()
+	if err != nil {
+		return "", ""
+	}
+	if token := strings.TrimSpace(string(out)); token != "" {
+		return token, "gh CLI (gh auth token)"
+	}
+	return "", ""
+}
+
+// ghHostsPath returns the location of gh's hosts.yml
+func ghHostsPath() string {
+	if dir := os.Getenv("GH_CONFIG_DIR"); dir != "" {
+		return filepath.Join(dir, "hosts.yml")
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("AppData"); dir != "" {
+			return filepath.Join(dir, "GitHub CLI", "hosts.yml")
+		}
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gh", "hosts.yml")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "." // Fallback
+	}
+	return filepath.Join(homeDir, ".config", "gh", "hosts.yml")
+}
+
+// parseGHHostsToken extracts oauth_token for host from hosts.yml. The file is a
+// simple nested mapping, so a line scanner is enough; the host's direct
+// oauth_token (the active user) wins over the per-user entries below it.
+func parseGHHostsToken(data []byte, host string) string {
+	var inHost bool
+	var best string
+	bestIndent := -1
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+
+		if indent == 0 {
+			inHost = strings.TrimSuffix(trimmed, ":") == host
+			continue
+		}
+		if !inHost {
+			continue
+		}
+
+		if value, ok := strings.CutPrefix(trimmed, "oauth_token:"); ok {
+			value = strings.Trim(strings.TrimSpace(value), `"'`)
+			if value != "" && (bestIndent < 0 || indent < bestIndent) {
+				best, bestIndent = value, indent
+			}
+		}
+	}
+	return best
+}
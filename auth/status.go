@@ -0,0 +1,38 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"claude-copilot/config"
+)
+
+// TokenStatus describes the token EnsureToken would use
+type TokenStatus struct {
+	Source   string   `json:"source"`
+	Login    string   `json:"login,omitempty"`
+	Verified bool     `json:"verified"`          // false when GitHub could not be reached
+	Skipped  []string `json:"skipped,omitempty"` // sources whose token is revoked or has no Copilot seat
+
+	Token string `json:"-"`
+}
+
+// CheckToken reports which token EnsureToken would pick, without starting the
+// device flow or saving anything. It returns ErrNoToken if none is usable.
+func CheckToken(ctx context.Context, cfg *config.AppConfig) (*TokenStatus, error) {
+	var skipped []string
+	for _, candidate := range tokenCandidates(cfg) {
+		info, err := ValidateToken(ctx, candidate.token)
+		switch {
+		case err == nil:
+			return &TokenStatus{Source: candidate.source, Login: info.Login, Verified: true, Skipped: skipped, Token: candidate.token}, nil
+		case errors.Is(err, ErrNetwork):
+			return &TokenStatus{Source: candidate.source, Skipped: skipped, Token: candidate.token}, err
+		case errors.Is(err, ErrTokenRevoked), errors.Is(err, ErrNoCopilotSubscription):
+			skipped = append(skipped, candidate.source+": "+err.Error())
+		default:
+			return nil, err
+		}
+	}
+	return &TokenStatus{Skipped: skipped}, ErrNoToken
+}
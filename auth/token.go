@@ -1,13 +1,24 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"sync"
 	"time"
 )
 
-const copilotInternalTokenURL = "https://api.github.com/copilot_internal/v2/token"
+const (
+	// expiryBuffer treats a token as expired slightly early so in-flight calls don't race the expiry
+	expiryBuffer = 30 * time.Second
+	// refreshAhead is how long before expiry the background loop refreshes
+	refreshAhead = 2 * time.Minute
+	minBackoff   = time.Second
+	maxBackoff   = time.Minute
+)
 
 // CopilotTokenResponse represents the token received to talk to the Copilot Chat API
 type CopilotTokenResponse struct {
@@ -16,52 +27,180 @@ type CopilotTokenResponse struct {
 	// ignoring other telemetry/tracking fields for now
 }
 
-// Cached session token
-var (
-	cachedCopilotToken string
-	tokenExpiresAt     time.Time
-)
+// TokenSource exchanges a GitHub OAuth token for Copilot session tokens and caches them.
+// It is safe for concurrent use: concurrent callers share a single in-flight refresh.
+type TokenSource struct {
+	githubToken string
+	client      *http.Client
+
+	// OnRefresh, if set before Start, is called after every refresh attempt
+	// with its error (nil on success)
+	OnRefresh func(err error)
+
+	mu         sync.Mutex
+	token      string
+	expiresAt  time.Time
+	lastErr    error
+	refreshing chan struct{} // non-nil while a refresh is in flight
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
 
-// GetCopilotToken exchanges the GitHub OAuth token for a Copilot Session Token.
-// It caches the token in memory until it expires.
-func GetCopilotToken(githubOauthToken string) (string, error) {
-	// Return cached token if still valid (adding 30 sec buffer)
-	if cachedCopilotToken != "" && time.Now().Add(30*time.Second).Before(tokenExpiresAt) {
-		return cachedCopilotToken, nil
+// NewTokenSource creates a TokenSource that uses the configured HTTP transport
+// (Insecure, CACertPath and proxy environment variables)
+func NewTokenSource(githubOauthToken string) *TokenSource {
+	return &TokenSource{
+		githubToken: githubOauthToken,
+		client:      newHTTPClient(),
+		stop:        make(chan struct{}),
 	}
+}
 
-	req, err := http.NewRequest("GET", copilotInternalTokenURL, nil)
-	if err != nil {
-		return "", err
+// Token returns a valid Copilot session token, refreshing it if needed
+func (ts *TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	if ts.validLocked() {
+		token := ts.token
+		ts.mu.Unlock()
+		return token, nil
 	}
+	done := ts.startRefreshLocked()
+	ts.mu.Unlock()
 
-	req.Header.Set("Authorization", "token "+githubOauthToken)
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Editor-Version", "vscode/1.90.0")
-	req.Header.Set("Editor-Plugin-Version", "copilot-chat/0.15.0")
-	req.Header.Set("User-Agent", "GitHubCopilotChat/0.15.0")
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.validLocked() {
+		return ts.token, nil
+	}
+	return "", ts.lastErr
+}
+
+// ExpiresAt returns the expiry of the cached token (zero if none has been fetched)
+func (ts *TokenSource) ExpiresAt() time.Time {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.expiresAt
+}
+
+// Start refreshes the token in the background shortly before it expires,
+// backing off with jitter when refreshes fail. Call Stop to end the loop.
+func (ts *TokenSource) Start() {
+	go ts.refreshLoop()
+}
+
+// Stop ends the background refresh loop
+func (ts *TokenSource) Stop() {
+	ts.stopOnce.Do(func() { close(ts.stop) })
+}
+
+func (ts *TokenSource) refreshLoop() {
+	backoff := minBackoff
+	for {
+		ts.mu.Lock()
+		done := ts.startRefreshLocked()
+		ts.mu.Unlock()
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
+		select {
+		case <-done:
+		case <-ts.stop:
+			return
+		}
+
+		ts.mu.Lock()
+		err, expiresAt := ts.lastErr, ts.expiresAt
+		ts.mu.Unlock()
+
+		var wait time.Duration
+		if err != nil {
+			// Full jitter keeps several proxies from retrying in lockstep
+			wait = backoff/2 + rand.N(backoff/2+1)
+			backoff = min(backoff*2, maxBackoff)
+			slog.Warn("Copilot トークンの更新に失敗しました", "retry_in", wait.Round(time.Second).String(), "error", err)
+		} else {
+			backoff = minBackoff
+			wait = max(time.Until(expiresAt)-refreshAhead, minBackoff)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ts.stop:
+			return
+		}
+	}
+}
+
+// startRefreshLocked starts a refresh unless one is already running and
+// returns a channel that is closed when it completes. ts.mu must be held.
+func (ts *TokenSource) startRefreshLocked() chan struct{} {
+	if ts.refreshing != nil {
+		return ts.refreshing
+	}
+	done := make(chan struct{})
+	ts.refreshing = done
+
+	go func() {
+		token, expiresAt, err := ts.fetch()
+
+		ts.mu.Lock()
+		if err == nil {
+			ts.token, ts.expiresAt = token, expiresAt
+		}
+		ts.lastErr = err
+		ts.refreshing = nil
+		ts.mu.Unlock()
+		if ts.OnRefresh != nil {
+			ts.OnRefresh(err)
+		}
+		close(done)
+	}()
+
+	return done
+}
+
+func (ts *TokenSource) validLocked() bool {
+	return ts.token != "" && time.Now().Add(expiryBuffer).Before(ts.expiresAt)
+}
+
+// fetch exchanges the GitHub OAuth token for a Copilot session token
+func (ts *TokenSource) fetch() (string, time.Time, error) {
+	req, err := http.NewRequest("GET", copilotInternalTokenURL(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	setCopilotHeaders(req, ts.githubToken)
+
+	resp, err := ts.client.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch copilot token: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to fetch copilot token: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to fetch copilot token, status %d", resp.StatusCode)
+		return "", time.Time{}, fmt.Errorf("failed to fetch copilot token, status %d", resp.StatusCode)
 	}
 
 	var tokenResp CopilotTokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return "", fmt.Errorf("failed to decode copilot token response: %w", err)
+		return "", time.Time{}, fmt.Errorf("failed to decode copilot token response: %w", err)
 	}
 
-	// Cache the properties
-	cachedCopilotToken = tokenResp.Token
-	tokenExpiresAt = time.Unix(tokenResp.ExpiresAt, 0)
+	slog.Info("refreshed GitHub Copilot API session token")
 
-	fmt.Println("🔄 Refreshed GitHub Copilot API Session Token")
+	return tokenResp.Token, time.Unix(tokenResp.ExpiresAt, 0), nil
+}
 
-	return cachedCopilotToken, nil
+// setCopilotHeaders adds the headers the Copilot internal API expects
+func setCopilotHeaders(req *http.Request, githubOauthToken string) {
+	req.Header.Set("Authorization", "token "+githubOauthToken)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Editor-Version", "vscode/1.90.0")
+	req.Header.Set("Editor-Plugin-Version", "copilot-chat/0.15.0")
+	req.Header.Set("User-Agent", "GitHubCopilotChat/0.15.0")
 }
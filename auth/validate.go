@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// validateTimeout bounds the whole token validation at startup
+const validateTimeout = 15 * time.Second
+
+var (
+	// ErrTokenRevoked means GitHub rejected the token (revoked, expired or malformed)
+	ErrTokenRevoked = errors.New("GitHub token has been revoked or has expired")
+	// ErrNoCopilotSubscription means the account is valid but has no Copilot seat
+	ErrNoCopilotSubscription = errors.New("GitHub account has no active Copilot subscription")
+	// ErrNetwork means GitHub could not be reached, so the token state is unknown
+	ErrNetwork = errors.New("could not reach GitHub to validate the token")
+)
+
+// TokenInfo describes a validated GitHub token
+type TokenInfo struct {
+	Login string
+}
+
+// ValidateToken checks the GitHub token against the user endpoint and the Copilot
+// entitlement (token exchange) endpoint. The returned error wraps one of
+// ErrTokenRevoked, ErrNoCopilotSubscription or ErrNetwork.
+func ValidateToken(ctx context.Context, githubToken string) (*TokenInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, validateTimeout)
+	defer cancel()
+
+	client := newHTTPClient()
+
+	// 1. Is the token itself still accepted?
+	req, err := http.NewRequestWithContext(ctx, "GET", githubUserURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "token "+githubToken)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, ErrTokenRevoked
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: user endpoint returned %s", ErrNetwork, resp.Status)
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode user response: %v", ErrNetwork, err)
+	}
+
+	// 2. Does the account have a Copilot seat?
+	req, err = http.NewRequestWithContext(ctx, "GET", copilotInternalTokenURL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	setCopilotHeaders(req, githubToken)
+
+	entitlement, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNetwork, err)
+	}
+	entitlement.Body.Close()
+
+	switch entitlement.StatusCode {
+	case http.StatusOK:
+		return &TokenInfo{Login: user.Login}, nil
+	case http.StatusUnauthorized:
+		return nil, ErrTokenRevoked
+	case http.StatusForbidden, http.StatusNotFound:
+		return nil, fmt.Errorf("%w (user: %s)", ErrNoCopilotSubscription, user.Login)
+	default:
+		return nil, fmt.Errorf("%w: entitlement endpoint returned %s", ErrNetwork, entitlement.Status)
+	}
+}
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-copilot/models"
+)
+
+// Entry is a cached response. Body holds either the JSON response or, for
+// streamed replies, the complete SSE event stream as it was sent to the client.
+type Entry struct {
+	Stream      bool      `json:"stream"`
+	ContentType string    `json:"content_type"`
+	Body        []byte    `json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Options configures the response cache
+type Options struct {
+	TTL        time.Duration // Lifetime of an entry
+	MaxEntries int           // Maximum number of entries kept (0 = unlimited)
+	MaxBytes   int64         // Maximum total body size kept (0 = unlimited)
+	Dir        string        // Optional on-disk store ("" = memory only)
+}
+
+type item struct {
+	key   string
+	entry *Entry
+}
+
+// Cache is an LRU response cache with TTL and size limits.
+// It is safe for concurrent use.
+type Cache struct {
+	opts Options
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+// New creates a cache. When opts.Dir is set, non-expired entries stored
+// there by a previous run are loaded back into memory.
+func New(opts Options) (*Cache, error) {
+	c := &Cache{
+		opts:    opts,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		if err := c.loadDir(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Key returns a canonical hash of the parts of a request that determine the
+// response. Responses are never shared across profiles (GitHub accounts) or
+// inbound API keys, so both are part of the key ("" when not used).
+func Key(req *models.AnthropicRequest, profile, apiKeyID string) (string, error) {
+	// encoding/json sorts map keys, so equal requests always marshal identically
+	data, err := json.Marshal(struct {
+		Profile     string                `json:"profile"`
+		APIKey      string                `json:"api_key"`
+		Model       string                `json:"model"`
+		System      interface{}           `json:"system"`
+		Messages    []models.AnthropicMsg `json:"messages"`
+		Tools       []interface{}         `json:"tools"`
+		Temperature *float64              `json:"temperature"`
+		Stream      bool                  `json:"stream"`
+	}{
+		Profile:     profile,
+		APIKey:      apiKeyID,
+		Model:       req.Model,
+		System:      req.System,
+		Messages:    req.Messages,
+		Tools:       req.Tools,
+		Temperature: req.Temperature,
+		Stream:      req.Stream,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal cache key: %w", err)
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Get returns the entry for key if present and not expired
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	it := el.Value.(*item)
+	if c.expired(it.entry) {
+		c.remove(el)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return it.entry, true
+}
+
+// Put stores an entry, evicting the least recently used entries to stay within limits
+func (c *Cache) Put(key string, entry *Entry) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if c.opts.MaxBytes > 0 && int64(len(entry.Body)) > c.opts.MaxBytes {
+		return // would never fit
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.insert(key, entry)
+
+	if c.opts.Dir != "" {
+		if err := c.writeFile(key, entry); err != nil {
+			slog.Warn("キャッシュの保存に失敗しました", "error", err)
+		}
+	}
+}
+
+// Len returns the number of entries currently held
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+func (c *Cache) insert(key string, entry *Entry) {
+	c.entries[key] = c.lru.PushFront(&item{key: key, entry: entry})
+	c.size += int64(len(entry.Body))
+
+	for c.lru.Len() > 0 &&
+		((c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries) ||
+			(c.opts.MaxBytes > 0 && c.size > c.opts.MaxBytes)) {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	it := el.Value.(*item)
+	c.lru.Remove(el)
+	delete(c.entries, it.key)
+	c.size -= int64(len(it.entry.Body))
+
+	if c.opts.Dir != "" {
+		os.Remove(c.filePath(it.key))
+	}
+}
+
+func (c *Cache) expired(entry *Entry) bool {
+	return c.opts.TTL > 0 && time.Since(entry.CreatedAt) > c.opts.TTL
+}
+
+func (c *Cache) filePath(key string) string {
+	return filepath.Join(c.opts.Dir, key+".json")
+}
+
+func (c *Cache) writeFile(key string, entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.filePath(key), data, 0600)
+}
+
+func (c *Cache) loadDir() error {
+	files, err := os.ReadDir(c.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	for _, f := range files {
+		key, ok := strings.CutSuffix(f.Name(), ".json")
+		if !ok || f.IsDir() {
+			continue
+		}
+		path := c.filePath(key)
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(data, &entry); err != nil || c.expired(&entry) {
+			os.Remove(path)
+			continue
+		}
+		c.insert(key, &entry)
+	}
+
+	return nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"claude-copilot/apikeys"
+	"claude-copilot/config"
+	"claude-copilot/tlscert"
+)
+
+// fallbackModel is suggested to Claude Code when neither a default model nor
+// model aliases are configured
+const fallbackModel = "GPT-5 mini"
+
+// Markers around the alias block in rc files, so setup-claude can replace it
+const (
+	rcBlockBegin = "# >>> claude-copilot >>>"
+	rcBlockEnd   = "# <<< claude-copilot <<<"
+)
+
+// claudeFlags are the options shared by `env` and `setup-claude`, on top of the
+// proxy's own flags (which determine the base URL and whether a key is needed)
+type claudeFlags struct {
+	shell      *string
+	configDir  *string
+	mainModel  *string
+	smallModel *string
+}
+
+func defineClaudeFlags(fs *flag.FlagSet) *claudeFlags {
+	return &claudeFlags{
+		shell:      fs.String("shell", detectShell(), "出力するシェルの形式: bash / zsh / fish / powershell"),
+		configDir:  fs.String("claude-config-dir", "~/.claude_copilot", "Claude Code の CLAUDE_CONFIG_DIR"),
+		mainModel:  fs.String("main-model", "", "Claude Code のメインモデル（ANTHROPIC_MODEL。省略時は model_aliases / -model から決定）"),
+		smallModel: fs.String("small-model", "", "Claude Code の軽量モデル（ANTHROPIC_SMALL_FAST_MODEL）"),
+	}
+}
+
+// envVar is one variable of Claude Code's environment
+type envVar struct {
+	name, value string
+}
+
+// claudeEnv returns the environment Claude Code needs to use the proxy
+func (c *claudeFlags) claudeEnv(f *serveFlags, cfg *config.AppConfig, token string) ([]envVar, error) {
+	endpoints, err := resolveEndpoints(f.listenValues(cfg), f.portString(cfg))
+	if err != nil {
+		return nil, err
+	}
+	useTLS := *f.tlsCert != "" || *f.tlsSelfSigned
+
+	mainModel, smallModel := claudeModels(cfg.ModelAliases, f.defaultModel(cfg))
+	if *c.mainModel != "" {
+		mainModel = *c.mainModel
+	}
+	if *c.smallModel != "" {
+		smallModel = *c.smallModel
+	}
+
+	vars := []envVar{
+		{"ANTHROPIC_BASE_URL", clientBaseURL(endpoints, useTLS, f.portString(cfg))},
+		{"ANTHROPIC_AUTH_TOKEN", token},
+		{"ANTHROPIC_MODEL", mainModel},
+		{"ANTHROPIC_SMALL_FAST_MODEL", smallModel},
+	}
+	if *f.tlsSelfSigned && *f.tlsCert == "" {
+		vars = append(vars, envVar{"NODE_EXTRA_CA_CERTS", filepath.Join(tlscert.DefaultDir(), "cert.pem")})
+	}
+	return vars, nil
+}
+
+// claudeModels picks the models Claude Code should request. With model aliases the
+// alias names are used (sonnet/opus for the main model, haiku for the small one),
+// so requests go through the alias table; otherwise the default model.
+func claudeModels(aliases map[string]string, defaultModel string) (mainModel, smallModel string) {
+	for _, family := range []string{"sonnet", "opus"} {
+		for _, name := range sortedKeys(aliases) {
+			if mainModel == "" && strings.Contains(strings.ToLower(name), family) {
+				mainModel = name
+			}
+		}
+	}
+	for _, name := range sortedKeys(aliases) {
+		if smallModel == "" && strings.Contains(strings.ToLower(name), "haiku") {
+			smallModel = name
+		}
+	}
+
+	if mainModel == "" {
+		mainModel = defaultModel
+	}
+	if mainModel == "" {
+		mainModel = fallbackModel
+	}
+	if smallModel == "" {
+		smallModel = mainModel
+	}
+	return mainModel, smallModel
+}
+
+// runEnvCommand implements `claude-copilot env`: exports for eval in a shell
+func runEnvCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("env", flag.ContinueOnError))
+	c := defineClaudeFlags(f.fs)
+	apiKey := f.fs.String("api-key", "", "ANTHROPIC_AUTH_TOKEN に設定する APIキー（-require-api-key 時）")
+	shown := []string{"shell", "claude-config-dir", "main-model", "small-model", "api-key", "port", "listen", "profile", "model"}
+	if code, ok := parseCommandFlags(f.fs, "env [FLAGS]", args, shown...); !ok {
+		return code
+	}
+	cfg, err := f.loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 設定の読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+	configDir := expandHome(*c.configDir)
+
+	token := *apiKey
+	if token == "" {
+		// A key written by setup-claude is reused
+		token = settingsToken(filepath.Join(configDir, "settings.json"))
+	}
+	if token == "" {
+		if f.apiKeyRequired(cfg) {
+			fmt.Fprintln(os.Stderr, "❌ APIキーが必要です。-api-key で指定するか、claude-copilot setup-claude で settings.json を作成してください")
+			return exitFailure
+		}
+		token = "dummy"
+	}
+
+	vars, err := c.claudeEnv(f, cfg, token)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		return exitFailure
+	}
+	vars = append(vars, envVar{"CLAUDE_CONFIG_DIR", configDir})
+
+	for _, v := range vars {
+		line, err := exportLine(*c.shell, v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitUsage
+		}
+		fmt.Println(line)
+	}
+	return exitOK
+}
+
+// runSetupClaudeCommand implements `claude-copilot setup-claude`
+func runSetupClaudeCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("setup-claude", flag.ContinueOnError))
+	c := defineClaudeFlags(f.fs)
+	writeSettings := f.fs.Bool("settings", true, "CLAUDE_CONFIG_DIR に settings.json を書き込む（APIキーを発行）")
+	installAlias := f.fs.Bool("alias", false, "シェルの設定ファイルにエイリアスを追加する")
+	aliasName := f.fs.String("alias-name", "copilot-claude", "エイリアス名")
+	rcFile := f.fs.String("rc", "", "エイリアスを追加するファイル（省略時はシェルから決定: ~/.bashrc など）")
+	keyName := f.fs.String("key-name", "claude-code", "発行する APIキーの名前")
+	shown := []string{"settings", "alias", "alias-name", "rc", "key-name", "shell", "claude-config-dir", "main-model", "small-model", "port", "listen", "profile", "model"}
+	if code, ok := parseCommandFlags(f.fs, "setup-claude [FLAGS]", args, shown...); !ok {
+		return code
+	}
+	if !*writeSettings && !*installAlias {
+		fmt.Fprintln(os.Stderr, "❌ -settings=false の場合は -alias を指定してください")
+		return exitUsage
+	}
+	cfg, err := f.loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 設定の読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+	configDir := expandHome(*c.configDir)
+	settingsPath := filepath.Join(configDir, "settings.json")
+
+	var vars []envVar
+	if *writeSettings {
+		store, err := apikeys.Open(apikeys.DefaultPath())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ APIキーの読み込みに失敗しました: %v\n", err)
+			return exitFailure
+		}
+		// Keep the key from an earlier run while it is still valid
+		token := settingsToken(settingsPath)
+		if _, ok := store.Verify(token); !ok {
+			secret, key, err := store.Create(*keyName)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ APIキーの作成に失敗しました: %v\n", err)
+				return exitFailure
+			}
+			token = secret
+			fmt.Printf("🔑 APIキーを発行しました (id: %s, name: %s)\n", key.ID, key.Name)
+		}
+
+		if vars, err = c.claudeEnv(f, cfg, token); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		if err := writeClaudeSettings(settingsPath, vars); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ settings.json の書き込みに失敗しました: %v\n", err)
+			return exitFailure
+		}
+		fmt.Printf("✅ %s を更新しました\n", settingsPath)
+		for _, v := range vars {
+			if v.name == "ANTHROPIC_AUTH_TOKEN" {
+				v.value = maskSecret(v.value)
+			}
+			fmt.Printf("   %s=%s\n", v.name, v.value)
+		}
+		if !f.apiKeyRequired(cfg) {
+			fmt.Println("   💡 発行したキーを必須にするには require_api_key を有効にしてください")
+		}
+	}
+
+	if *installAlias {
+		// With settings.json in place, the alias only has to select the config dir;
+		// NODE_EXTRA_CA_CERTS is read by Node before settings.json, so it stays
+		aliasVars := []envVar{{"CLAUDE_CONFIG_DIR", configDir}}
+		if !*writeSettings {
+			token := "dummy"
+			if f.apiKeyRequired(cfg) {
+				fmt.Fprintln(os.Stderr, "⚠️  -require-api-key が有効です。ANTHROPIC_AUTH_TOKEN を別途設定してください")
+			}
+			all, err := c.claudeEnv(f, cfg, token)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				return exitFailure
+			}
+			aliasVars = append(all, aliasVars...)
+		} else {
+			for _, v := range vars {
+				if v.name == "NODE_EXTRA_CA_CERTS" {
+					aliasVars = append(aliasVars, v)
+				}
+			}
+		}
+
+		path := *rcFile
+		if path == "" {
+			path = rcPath(*c.shell)
+		}
+		block, err := aliasBlock(*c.shell, *aliasName, aliasVars)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitUsage
+		}
+		if err := installRCBlock(path, block); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ エイリアスの追加に失敗しました: %v\n", err)
+			return exitFailure
+		}
+		fmt.Printf("✅ エイリアス %s を %s に追加しました（新しいシェルで有効になります）\n", *aliasName, path)
+	}
+	return exitOK
+}
+
+// settingsToken returns the auth token in a Claude Code settings.json, if any
+func settingsToken(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var settings struct {
+		Env map[string]string `json:"env"`
+	}
+	if json.Unmarshal(data, &settings) != nil {
+		return ""
+	}
+	return settings.Env["ANTHROPIC_AUTH_TOKEN"]
+}
+
+// writeClaudeSettings merges vars into the "env" block of a Claude Code
+// settings.json, keeping every other setting
+func writeClaudeSettings(path string, vars []envVar) error {
+	settings := map[string]any{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return fmt.Errorf("%s is not valid JSON: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	env, _ := settings["env"].(map[string]any)
+	if env == nil {
+		env = map[string]any{}
+	}
+	for _, v := range vars {
+		if v.name == "NODE_EXTRA_CA_CERTS" {
+			continue // only effective when exported before Node starts
+		}
+		env[v.name] = v.value
+	}
+	settings["env"] = env
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// The file holds an API key. WriteFile keeps the mode of an existing file, so
+	// restrict it before the key is written.
+	if err := os.Chmod(path, 0600); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// exportLine formats a variable assignment for shell
+func exportLine(shell string, v envVar) (string, error) {
+	switch shell {
+	case "bash", "zsh", "sh":
+		return "export " + v.name + "=" + shellQuote(v.value), nil
+	case "fish":
+		return "set -gx " + v.name + " " + shellQuote(v.value), nil
+	case "powershell", "pwsh":
+		return "$env:" + v.name + " = " + powershellQuote(v.value), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q (bash, zsh, fish or powershell)", shell)
+}
+
+// aliasBlock returns the rc file snippet defining name to run claude with vars
+func aliasBlock(shell, name string, vars []envVar) (string, error) {
+	var b strings.Builder
+	b.WriteString(rcBlockBegin + "\n")
+	switch shell {
+	case "bash", "zsh", "sh":
+		command := "claude"
+		for i := len(vars) - 1; i >= 0; i-- {
+			command = vars[i].name + "=" + shellQuote(vars[i].value) + " " + command
+		}
+		fmt.Fprintf(&b, "alias %s=%s\n", name, shellQuote(command))
+	case "fish":
+		command := "env"
+		for _, v := range vars {
+			command += " " + v.name + "=" + shellQuote(v.value)
+		}
+		fmt.Fprintf(&b, "alias %s %s\n", name, shellQuote(command+" claude"))
+	case "powershell", "pwsh":
+		// Aliases cannot carry variables; set them for the call only
+		fmt.Fprintf(&b, "function %s {\n", name)
+		for _, v := range vars {
+			fmt.Fprintf(&b, "\t$prev_%s = $env:%s; $env:%s = %s\n", v.name, v.name, v.name, powershellQuote(v.value))
+		}
+		b.WriteString("\ttry { claude @args } finally {\n")
+		for _, v := range vars {
+			fmt.Fprintf(&b, "\t\t$env:%s = $prev_%s\n", v.name, v.name)
+		}
+		b.WriteString("\t}\n}\n")
+	default:
+		return "", fmt.Errorf("unsupported shell %q (bash, zsh, fish or powershell)", shell)
+	}
+	b.WriteString(rcBlockEnd + "\n")
+	return b.String(), nil
+}
+
+// installRCBlock adds block to an rc file, replacing one added earlier
+func installRCBlock(path, block string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	content := string(data)
+
+	begin := strings.Index(content, rcBlockBegin)
+	end := strings.Index(content, rcBlockEnd)
+	if begin >= 0 && end > begin {
+		content = content[:begin] + block + strings.TrimPrefix(content[end+len(rcBlockEnd):], "\n")
+	} else {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += block
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// detectShell guesses the user's shell from $SHELL
+func detectShell() string {
+	if shell := filepath.Base(os.Getenv("SHELL")); shell == "bash" || shell == "zsh" || shell == "fish" {
+		return shell
+	}
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	return "bash"
+}
+
+// rcPath returns the startup file of shell
+func rcPath(shell string) string {
+	home, _ := os.UserHomeDir()
+	switch shell {
+	case "zsh":
+		if dir := os.Getenv("ZDOTDIR"); dir != "" {
+			return filepath.Join(dir, ".zshrc")
+		}
+		return filepath.Join(home, ".zshrc")
+	case "fish":
+		return filepath.Join(home, ".config", "fish", "config.fish")
+	case "powershell", "pwsh":
+		if runtime.GOOS == "windows" {
+			return filepath.Join(home, "Documents", "PowerShell", "Microsoft.PowerShell_profile.ps1")
+		}
+		return filepath.Join(home, ".config", "powershell", "Microsoft.PowerShell_profile.ps1")
+	default:
+		return filepath.Join(home, ".bashrc")
+	}
+}
+
+// expandHome expands a leading ~ to the home directory
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+func powershellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	copilot "github.com/github/copilot-sdk/go"
+
+	"claude-copilot/auth"
+	"claude-copilot/config"
+)
+
+// buildClientOptions builds the Copilot SDK client options and the environment of
+// the embedded CLI from the resolved flags, describing the choices on out. It also
+// reports whether an HTTP(S) proxy is configured, for troubleshooting hints, and
+// returns a function removing the temporary files it created (once the CLI stops).
+func buildClientOptions(f *serveFlags, cfg *config.AppConfig, out io.Writer) (opts *copilot.ClientOptions, hasProxy bool, cleanup func()) {
+	cleanup = func() {}
+	opts = &copilot.ClientOptions{
+		GitHubToken: cfg.GitHubToken, // Pass our device-auth token to SDK
+	}
+	if *f.copilotCLIPath != "" {
+		opts.CLIPath = *f.copilotCLIPath
+	}
+	if *f.sdkDebug {
+		opts.LogLevel = "debug"
+	}
+
+	// Build environment variables for the embedded CLI process
+	cliEnv := os.Environ()
+	if auth.GitHubHost != config.DefaultGitHubHost {
+		// The Copilot CLI follows the gh convention for non-github.com hosts
+		cliEnv = setEnvValue(cliEnv, "GH_HOST", auth.GitHubHost)
+	}
+
+	// Show proxy configuration (mask credentials)
+	for _, key := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY", "https_proxy", "http_proxy", "no_proxy"} {
+		if v := os.Getenv(key); v != "" {
+			hasProxy = true
+			fmt.Fprintf(out, "🌐 Proxy: %s=%s\n", key, sanitizeProxyValue(v))
+		}
+	}
+
+	// --insecure: Skip TLS certificate verification for the embedded Node.js CLI
+	// (useful when corporate proxy performs SSL interception)
+	if *f.insecure || os.Getenv("NODE_TLS_REJECT_UNAUTHORIZED") == "0" {
+		cliEnv = append(cliEnv, "NODE_TLS_REJECT_UNAUTHORIZED=0")
+		fmt.Fprintln(out, "⚠️  TLS証明書検証を無効化しています (--insecure)")
+	} else if hasProxy {
+		fmt.Fprintln(out, "💡 プロキシ環境でTLSエラーが発生する場合は --insecure オプションを試してください")
+		fmt.Fprintln(out, "   より安全な方法: --ca-cert /path/to/corporate-ca.pem")
+	}
+
+	// --ca-cert or NODE_EXTRA_CA_CERTS: Add custom CA certificate
+	if *f.caCert != "" {
+		cliEnv = setEnvValue(cliEnv, "NODE_EXTRA_CA_CERTS", *f.caCert)
+		fmt.Fprintf(out, "🔐 CA証明書を追加: %s\n", *f.caCert)
+	} else if v := os.Getenv("NODE_EXTRA_CA_CERTS"); v != "" {
+		fmt.Fprintf(out, "🔐 CA証明書 (env): %s\n", v)
+	}
+
+	if *f.cliInstallVerbose {
+		cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_INSTALL_VERBOSE", "1")
+		fmt.Fprintln(out, "🧩 Copilot CLI install verbose enabled")
+	}
+
+	// Copilot CLI path override (flag > env)
+	if *f.copilotCLIPath != "" {
+		cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_PATH", *f.copilotCLIPath)
+		fmt.Fprintf(out, "🧭 Copilot CLI path: %s\n", *f.copilotCLIPath)
+	} else if v := os.Getenv("COPILOT_CLI_PATH"); v != "" {
+		cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_PATH", v)
+		fmt.Fprintf(out, "🧭 Copilot CLI path (env): %s\n", v)
+	}
+
+	// Capture CLI stderr if requested (requires explicit CLI path)
+	if *f.cliStderr != "" {
+		resolvedCLIPath := *f.copilotCLIPath
+		if resolvedCLIPath == "" {
+			resolvedCLIPath = os.Getenv("COPILOT_CLI_PATH")
+		}
+		if resolvedCLIPath == "" {
+			fmt.Fprintln(out, "⚠️  --cli-stderr を指定する場合は --copilot-cli も指定してください")
+		} else {
+			wrapperPath, err := createCLIWrapper(resolvedCLIPath, *f.cliStderr)
+			if err != nil {
+				fmt.Fprintf(out, "⚠️  CLI stderr wrapper 作成に失敗: %v\n", err)
+			} else {
+				opts.CLIPath = wrapperPath
+				cleanup = func() { os.Remove(wrapperPath) }
+				cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_PATH", wrapperPath)
+				fmt.Fprintf(out, "🧾 CLI stderr: %s\n", *f.cliStderr)
+			}
+		}
+	}
+
+	// Node.js runtime overrides
+	if *f.nodeOptions != "" {
+		cliEnv = setEnvValue(cliEnv, "NODE_OPTIONS", *f.nodeOptions)
+		fmt.Fprintln(out, "🧪 NODE_OPTIONS set")
+	}
+	if *f.nodePath != "" {
+		cliEnv = setEnvValue(cliEnv, "NODE_PATH", *f.nodePath)
+		fmt.Fprintf(out, "🧭 NODE_PATH: %s\n", *f.nodePath)
+	}
+	if *f.nodeBin != "" {
+		pathValue := *f.nodeBin + string(os.PathListSeparator) + os.Getenv("PATH")
+		cliEnv = setEnvValue(cliEnv, "PATH", pathValue)
+		fmt.Fprintf(out, "🧭 PATH (prepend): %s\n", *f.nodeBin)
+	}
+
+	opts.Env = cliEnv
+	return opts, hasProxy, cleanup
+}
+
+// configureAuth propagates the TLS, host and token source options to the auth
+// package (for the Go HTTP requests to GitHub)
+func (f *serveFlags) configureAuth(cfg *config.AppConfig) {
+	auth.Insecure = *f.insecure
+	auth.CACertPath = *f.caCert
+
+	// GitHub host: flag > profile > config file > github.com
+	auth.GitHubHost = cfg.Host()
+	auth.APIBaseURL = cfg.GitHubAPIURL
+	if *f.githubAPIURL != "" {
+		auth.APIBaseURL = *f.githubAPIURL
+	}
+
+	auth.TokenFile = *f.tokenFile
+	auth.NoDeviceFlow = *f.noDeviceFlow
+	auth.OpenBrowser = *f.openBrowser
+	auth.ShowQRCode = *f.showQR
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"syscall"
+
+	copilot "github.com/github/copilot-sdk/go"
+
+	"claude-copilot/auth"
+	"claude-copilot/config"
+)
+
+// Exit codes shared by all commands
+const (
+	exitOK          = 0
+	exitFailure     = 1 // the command failed (e.g. not logged in)
+	exitUsage       = 2 // bad command line
+	exitUnavailable = 3 // GitHub or the Copilot CLI could not be reached
+)
+
+// command is a `claude-copilot COMMAND`
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in help order (filled in init to allow `help` to refer to it)
+var commands []command
+
+func init() {
+	commands = []command{
+		{"serve", "プロキシを起動（コマンド省略時のデフォルト）", runServeCommand},
+		{"login", "GitHub にログイン（デバイス認証）", runLoginCommand},
+		{"logout", "保存した認証情報を削除", runLogoutCommand},
+		{"status", "認証状態を確認（0 = 認証済み / 1 = 未認証 / 3 = 確認できない）", runStatusCommand},
+		{"models", "利用可能なモデルの一覧を表示", runModelsCommand},
+		{"doctor", "プロキシ・TLS・Copilot CLI・認証の接続診断", runDoctorCommand},
+		{"env", "Claude Code 用の環境変数を出力（eval \"$(claude-copilot env)\"）", runEnvCommand},
+		{"setup-claude", "Claude Code の settings.json とエイリアスを作成", runSetupClaudeCommand},
+		{"config", "設定の表示・検証", runConfigCommand},
+		{"profile", "プロファイル（複数アカウント）の管理", runProfileCommand},
+		{"keys", "受信リクエスト用 APIキーの管理", runKeysCommand},
+		{"audit", "監査ログの表示（audit tail）", runAuditCommand},
+		{"usage", "APIキー・モデルごとの利用量レポート（usage daily / monthly）", runUsageCommand},
+		{"version", "バージョンを表示", runVersionCommand},
+		{"completion", "シェル補完スクリプトを出力（bash / zsh / fish / powershell）", runCompletionCommand},
+		{"help", "コマンドのヘルプを表示", runHelpCommand},
+	}
+}
+
+// runCommand dispatches to the subcommand named by args[0]. Without one (or when
+// the first argument is a flag, as in older versions) the proxy is started.
+func runCommand(args []string) int {
+	if len(args) == 0 {
+		return runServeCommand(args)
+	}
+	switch args[0] {
+	case "-h", "-help", "--help":
+		return runHelpCommand(nil)
+	case "-version", "--version":
+		return runVersionCommand(nil)
+	}
+	if strings.HasPrefix(args[0], "-") {
+		return runServeCommand(args)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			return cmd.run(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	printUsage(os.Stderr)
+	return exitUsage
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "claude-copilot %s - Claude Code から GitHub Copilot を使うためのプロキシ\n\n", version)
+	fmt.Fprintln(w, "Usage:")
+	fmt.Fprintln(w, "  claude-copilot [COMMAND] [FLAGS]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-13s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "各コマンドのヘルプ: claude-copilot help COMMAND または claude-copilot COMMAND -h")
+}
+
+// runHelpCommand implements `claude-copilot help [COMMAND]`
+func runHelpCommand(args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == args[0] && cmd.name != "help" {
+			return cmd.run([]string{"-h"})
+		}
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	return exitUsage
+}
+
+// isHelpArg reports whether arg asks for help (for commands with their own argument parsing)
+func isHelpArg(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help" || arg == "help"
+}
+
+// parseCommandFlags parses args with a usage message naming the command. When shown
+// is given, help lists only those flags (the others are still accepted). ok is false
+// when the command should exit with code.
+func parseCommandFlags(fs *flag.FlagSet, usage string, args []string, shown ...string) (code int, ok bool) {
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: claude-copilot %s\n\nFlags:\n", usage)
+		if len(shown) == 0 {
+			fs.PrintDefaults()
+			return
+		}
+		// PrintDefaults has no filter, so print through a copy with just the shown flags
+		subset := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+		subset.SetOutput(out)
+		fs.VisitAll(func(fl *flag.Flag) {
+			if slices.Contains(shown, fl.Name) {
+				subset.Var(fl.Value, fl.Name, fl.Usage)
+			}
+		})
+		subset.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected argument %q\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// authFlags are the flags relevant to commands that only talk to GitHub
+var authFlags = []string{"profile", "github-host", "github-api-url", "credential-store", "token-file", "insecure", "ca-cert"}
+
+// loadAuthConfig resolves the configuration and prepares the auth package,
+// as the proxy does on startup
+func loadAuthConfig(f *serveFlags) (*config.AppConfig, bool) {
+	cfg, err := f.loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 設定の読み込みに失敗しました: %v\n", err)
+		return nil, false
+	}
+	f.configureAuth(cfg)
+	return cfg, true
+}
+
+// runLoginCommand implements `claude-copilot login`
+func runLoginCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("login", flag.ContinueOnError))
+	force := f.fs.Bool("force", false, "有効なトークンがあってもデバイス認証をやり直す（アカウントの切り替え）")
+	shown := append([]string{"force", "open-browser", "qr"}, authFlags...)
+	if code, ok := parseCommandFlags(f.fs, "login [FLAGS]", args, shown...); !ok {
+		return code
+	}
+	cfg, ok := loadAuthConfig(f)
+	if !ok {
+		return exitFailure
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !*force {
+		if status, err := auth.CheckToken(ctx, cfg); err == nil {
+			fmt.Printf("✅ ログイン済みです（user: %s, source: %s）\n", status.Login, status.Source)
+			fmt.Println("   別のアカウントでログインし直す場合は -force を指定してください")
+			return exitOK
+		}
+	}
+
+	if err := auth.Login(ctx, cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ ログインに失敗しました: %v\n", err)
+		return exitFailure
+	}
+	return exitOK
+}
+
+// runLogoutCommand implements `claude-copilot logout` (and the older -logoff flag)
+func runLogoutCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("logout", flag.ContinueOnError))
+	if code, ok := parseCommandFlags(f.fs, "logout [FLAGS]", args, "github-host"); !ok {
+		return code
+	}
+	// Tokens from `login --github-host` are stored under that host
+	if err := f.applyEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 設定の読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+	config.GitHubHostOverride = *f.githubHost
+
+	if err := config.DeleteTokens(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 認証情報の削除に失敗しました: %v\n", err)
+		return exitFailure
+	}
+	fmt.Println("✅ 保存したトークンを削除しました（その他の設定は残しています）")
+	return exitOK
+}
+
+// runStatusCommand implements `claude-copilot status`. The exit code tells
+// scripts whether the proxy could authenticate without user interaction.
+func runStatusCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("status", flag.ContinueOnError))
+	jsonOutput := f.fs.Bool("json", false, "JSON で出力")
+	if code, ok := parseCommandFlags(f.fs, "status [FLAGS]", args, append([]string{"json"}, authFlags...)...); !ok {
+		return code
+	}
+	cfg, ok := loadAuthConfig(f)
+	if !ok {
+		return exitFailure
+	}
+
+	status, err := auth.CheckToken(context.Background(), cfg)
+	code := exitOK
+	switch {
+	case errors.Is(err, auth.ErrNetwork):
+		code = exitUnavailable
+	case err != nil:
+		code = exitFailure
+	}
+
+	if *jsonOutput {
+		result := struct {
+			Authenticated bool   `json:"authenticated"`
+			Host          string `json:"host"`
+			Profile       string `json:"profile,omitempty"`
+			*auth.TokenStatus
+			Error string `json:"error,omitempty"`
+		}{Authenticated: code == exitOK, Host: auth.GitHubHost, Profile: cfg.Profile, TokenStatus: status}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return code
+	}
+
+	fmt.Printf("GitHub host:  %s\n", auth.GitHubHost)
+	if cfg.Profile != "" {
+		fmt.Printf("Profile:      %s\n", cfg.Profile)
+	}
+	if status != nil {
+		for _, skipped := range status.Skipped {
+			fmt.Printf("Skipped:      %s\n", skipped)
+		}
+		if status.Source != "" {
+			fmt.Printf("Token:        %s\n", status.Source)
+		}
+		if status.Login != "" {
+			fmt.Printf("User:         %s\n", status.Login)
+		}
+	}
+	switch code {
+	case exitOK:
+		fmt.Println("Status:       ✅ 認証済み（Copilot 利用可）")
+	case exitUnavailable:
+		fmt.Printf("Status:       ⚠️  確認できません: %v\n", err)
+	default:
+		fmt.Println("Status:       ❌ 未認証（claude-copilot login でログインしてください）")
+	}
+	return code
+}
+
+// runModelsCommand implements `claude-copilot models`
+func runModelsCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("models", flag.ContinueOnError))
+	jsonOutput := f.fs.Bool("json", false, "JSON で出力")
+	shown := append([]string{"json", "copilot-cli", "node-bin"}, authFlags...)
+	if code, ok := parseCommandFlags(f.fs, "models [FLAGS]", args, shown...); !ok {
+		return code
+	}
+	cfg, ok := loadAuthConfig(f)
+	if !ok {
+		return exitFailure
+	}
+
+	ctx := context.Background()
+	status, err := auth.CheckToken(ctx, cfg)
+	if err != nil && !errors.Is(err, auth.ErrNetwork) {
+		fmt.Fprintf(os.Stderr, "❌ ログインしていません（claude-copilot login）: %v\n", err)
+		return exitFailure
+	}
+
+	opts, _, cleanup := buildClientOptions(f, cfg, io.Discard)
+	defer cleanup()
+	opts.GitHubToken = status.Token
+	client := copilot.NewClient(opts)
+	if err := client.Start(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Copilot CLI の起動に失敗しました: %v\n", err)
+		return exitUnavailable
+	}
+	defer client.Stop()
+
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ モデル一覧の取得に失敗しました: %v\n", err)
+		return exitUnavailable
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(models)
+		return exitOK
+	}
+
+	fmt.Printf("%-28s %-28s %-10s %s\n", "ID", "NAME", "MULTIPLIER", "POLICY")
+	for _, m := range models {
+		multiplier, policy := "-", "-"
+		if m.Billing != nil {
+			multiplier = fmt.Sprintf("%gx", m.Billing.Multiplier)
+		}
+		if m.Policy != nil && m.Policy.State != "" {
+			policy = m.Policy.State
+		}
+		fmt.Printf("%-28s %-28s %-10s %s\n", m.ID, m.Name, multiplier, policy)
+	}
+	return exitOK
+}
+
+// runVersionCommand implements `claude-copilot version`
+func runVersionCommand(args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	if code, ok := parseCommandFlags(fs, "version", args); !ok {
+		return code
+	}
+
+	sdkVersion := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/github/copilot-sdk/go" {
+				sdkVersion = dep.Version
+			}
+		}
+	}
+	fmt.Printf("claude-copilot %s (copilot-sdk %s, %s, %s/%s)\n", version, sdkVersion, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return exitOK
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// runCompletionCommand implements `claude-copilot completion SHELL`
+func runCompletionCommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  claude-copilot completion bash        # ~/.bashrc: source <(claude-copilot completion bash)")
+		fmt.Println("  claude-copilot completion zsh         # ~/.zshrc:  source <(claude-copilot completion zsh)")
+		fmt.Println("  claude-copilot completion fish        # claude-copilot completion fish > ~/.config/fish/completions/claude-copilot.fish")
+		fmt.Println("  claude-copilot completion powershell  # $PROFILE: claude-copilot completion powershell | Out-String | Invoke-Expression")
+	}
+	if len(args) != 1 {
+		usage()
+		return exitUsage
+	}
+
+	names := make([]string, len(commands))
+	for i, cmd := range commands {
+		names[i] = cmd.name
+	}
+	var flags []string
+	defineServeFlags(flag.NewFlagSet("serve", flag.ContinueOnError)).fs.VisitAll(func(fl *flag.Flag) {
+		flags = append(flags, "-"+fl.Name)
+	})
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(bashCompletion(names, flags))
+	case "zsh":
+		fmt.Print(zshCompletion(names, flags))
+	case "fish":
+		fmt.Print(fishCompletion(names, flags))
+	case "powershell", "pwsh":
+		fmt.Print(powershellCompletion(names, flags))
+	case "-h", "-help", "--help", "help":
+		usage()
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unsupported shell %q\n", args[0])
+		usage()
+		return exitUsage
+	}
+	return exitOK
+}
+
+// The scripts complete command names in the first position and the proxy's
+// flags (which every command that takes flags accepts) anywhere.
+
+func bashCompletion(names, flags []string) string {
+	return fmt.Sprintf(`# bash completion for claude-copilot
+_claude_copilot() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	if [ "$COMP_CWORD" -eq 1 ] && [[ "$cur" != -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	else
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+	fi
+}
+complete -o default -F _claude_copilot claude-copilot
+`, strings.Join(names, " "), strings.Join(flags, " "))
+}
+
+func zshCompletion(names, flags []string) string {
+	return fmt.Sprintf(`#compdef claude-copilot
+# zsh completion for claude-copilot
+_claude_copilot() {
+	if (( CURRENT == 2 )) && [[ "$PREFIX" != -* ]]; then
+		compadd -- %s
+	elif [[ "$PREFIX" == -* ]]; then
+		compadd -- %s
+	else
+		_files
+	fi
+}
+compdef _claude_copilot claude-copilot
+`, strings.Join(names, " "), strings.Join(flags, " "))
+}
+
+func fishCompletion(names, flags []string) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for claude-copilot\n")
+	fmt.Fprintf(&b, "complete -c claude-copilot -n __fish_use_subcommand -f -a %q\n", strings.Join(names, " "))
+	for _, name := range flags {
+		fmt.Fprintf(&b, "complete -c claude-copilot -o %s\n", strings.TrimPrefix(name, "-"))
+	}
+	return b.String()
+}
+
+func powershellCompletion(names, flags []string) string {
+	return fmt.Sprintf(`# PowerShell completion for claude-copilot
+Register-ArgumentCompleter -Native -CommandName claude-copilot -ScriptBlock {
+	param($wordToComplete, $commandAst, $cursorPosition)
+	$commands = @(%s)
+	$flags = @(%s)
+	$candidates = if ($commandAst.CommandElements.Count -le 2 -and -not $wordToComplete.StartsWith('-')) { $commands } else { $flags }
+	$candidates | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+		[System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+	}
+}
+`, psList(names), psList(flags))
+}
+
+func psList(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = "'" + w + "'"
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -1,52 +1,409 @@
 package config
 
 import (
+	"cmp"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"claude-copilot/credstore"
 )
 
+// CredentialStoreOverride selects the credential backend regardless of the
+// config file (set by --credential-store). The choice is persisted on load.
+var CredentialStoreOverride string
+
+// GitHubHostOverride selects the GitHub host regardless of the config file
+// (set by --github-host). It is not persisted.
+var GitHubHostOverride string
+
+// DefaultGitHubHost is used when no host is configured
+const DefaultGitHubHost = "github.com"
+
 // AppConfig holds the necessary configurations for the proxy
 type AppConfig struct {
-	Port        string `json:"port"`
-	GitHubToken string `json:"github_token"`
+	Port   string `json:"port"`
+	Listen string `json:"listen,omitempty"` // host or host:port (default 127.0.0.1)
+	// GitHubToken is only written to the file when CredentialStore is "plaintext";
+	// otherwise it lives in the credential store and is filled in on load.
+	GitHubToken     string              `json:"github_token,omitempty"`
+	CredentialStore string              `json:"credential_store,omitempty"`
+	Model           string              `json:"model,omitempty"`          // Default model when a request omits one
+	GitHubHost      string              `json:"github_host,omitempty"`    // e.g. "github.com", "acme.ghe.com", "ghes.example.com"
+	GitHubAPIURL    string              `json:"github_api_url,omitempty"` // Overrides the API base derived from GitHubHost
+	Profiles        map[string]*Profile `json:"profiles,omitempty"`
+	RequireAPIKey   bool                `json:"require_api_key,omitempty"` // Same as --require-api-key
+	ModelAliases    map[string]string   `json:"model_aliases,omitempty"`   // Requested model name -> Copilot model
+	RedactPatterns  []string            `json:"redact_patterns,omitempty"` // Extra regexps masked in logs and the audit log
+	KeyBudgets      map[string]*Budget  `json:"key_budgets,omitempty"`     // Per API key (name or ID), replacing the budget_* options
+
+	// Options mirroring the command-line flags of the same name (in snake_case).
+	// They apply when the flag and its environment variable are not set.
+	Debug                 bool    `json:"debug,omitempty"`
+	Insecure              bool    `json:"insecure,omitempty"`
+	CACert                string  `json:"ca_cert,omitempty"`
+	CopilotCLI            string  `json:"copilot_cli,omitempty"`
+	NodeOptions           string  `json:"node_options,omitempty"`
+	NodePath              string  `json:"node_path,omitempty"`
+	NodeBin               string  `json:"node_bin,omitempty"`
+	CLIInstallVerbose     bool    `json:"cli_install_verbose,omitempty"`
+	SDKDebug              bool    `json:"sdk_debug,omitempty"`
+	CLIStderr             string  `json:"cli_stderr,omitempty"`
+	ProfileHeader         bool    `json:"profile_header,omitempty"`
+	OpenBrowser           bool    `json:"open_browser,omitempty"`
+	QR                    *bool   `json:"qr,omitempty"`
+	TokenFile             string  `json:"token_file,omitempty"`
+	NoDeviceFlow          bool    `json:"no_device_flow,omitempty"`
+	TLSCert               string  `json:"tls_cert,omitempty"`
+	TLSKey                string  `json:"tls_key,omitempty"`
+	TLSSelfSigned         bool    `json:"tls_self_signed,omitempty"`
+	Cache                 bool    `json:"cache,omitempty"`
+	CacheTTL              string  `json:"cache_ttl,omitempty"`
+	CacheMaxEntries       *int    `json:"cache_max_entries,omitempty"`
+	CacheMaxBytes         *int64  `json:"cache_max_bytes,omitempty"`
+	CacheDir              string  `json:"cache_dir,omitempty"`
+	MaxConcurrentRequests int     `json:"max_concurrent_requests,omitempty"` // 0 = unlimited
+	MaxQueuedRequests     int     `json:"max_queued_requests,omitempty"`     // 0 = unlimited
+	UpstreamRetries       *int    `json:"upstream_retries,omitempty"`
+	ShutdownGrace         string  `json:"shutdown_grace,omitempty"`
+	LogLevel              string  `json:"log_level,omitempty"`  // debug, info, warn or error
+	LogFormat             string  `json:"log_format,omitempty"` // text or json
+	AuditLog              string  `json:"audit_log,omitempty"`
+	AuditPrompts          bool    `json:"audit_prompts,omitempty"`
+	AuditRedact           string  `json:"audit_redact,omitempty"`
+	AuditMaxSize          *int64  `json:"audit_max_size,omitempty"`
+	AuditMaxAge           string  `json:"audit_max_age,omitempty"`
+	AuditMaxBackups       *int    `json:"audit_max_backups,omitempty"`
+	TraceEndpoint         string  `json:"trace_endpoint,omitempty"` // OTLP/HTTP collector
+	TraceFile             string  `json:"trace_file,omitempty"`
+	Usage                 *bool   `json:"usage,omitempty"`
+	BudgetDailySoft       float64 `json:"budget_daily_soft,omitempty"` // premium requests per API key
+	BudgetDailyHard       float64 `json:"budget_daily_hard,omitempty"`
+	BudgetMonthlySoft     float64 `json:"budget_monthly_soft,omitempty"`
+	BudgetMonthlyHard     float64 `json:"budget_monthly_hard,omitempty"`
+
+	// Profile is the name of the selected profile ("" = top-level settings)
+	Profile string `json:"-"`
+	// base keeps the top-level settings while a profile is selected
+	base *Profile
+	// externalToken marks GitHubToken as coming from outside (env, gh CLI, token file)
+	externalToken bool
+	// raw is the config file as read, for option lookup and validation
+	raw map[string]json.RawMessage
+	// sources records where top-level settings came from ("file", "env NAME", "profile NAME")
+	sources map[string]string
 }
 
-// LoadConfig reads the config or creates a default one
-func LoadConfig() (*AppConfig, error) {
-	configPath := GetConfigPath()
+// UseExternalToken sets a token that is managed outside the proxy (environment,
+// gh CLI, token file). SaveConfig never persists it.
+func (cfg *AppConfig) UseExternalToken(token string) {
+	cfg.GitHubToken = token
+	cfg.externalToken = true
+}
+
+// Profile is a named GitHub account with its own token, default model and port
+type Profile struct {
+	Port         string `json:"port,omitempty"`
+	Model        string `json:"model,omitempty"`
+	GitHubHost   string `json:"github_host,omitempty"`
+	GitHubAPIURL string `json:"github_api_url,omitempty"`
+	GitHubToken  string `json:"github_token,omitempty"` // plaintext store only
+}
 
-	// Create default config if not exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		defaultCfg := &AppConfig{
-			Port: "8080", // Default proxy port
+// Budget limits the premium requests of an inbound API key (0 = no limit)
+type Budget struct {
+	DailySoft   float64 `json:"daily_soft,omitempty"`
+	DailyHard   float64 `json:"daily_hard,omitempty"`
+	MonthlySoft float64 `json:"monthly_soft,omitempty"`
+	MonthlyHard float64 `json:"monthly_hard,omitempty"`
+}
+
+// Host returns the effective GitHub host
+func (cfg *AppConfig) Host() string {
+	switch {
+	case GitHubHostOverride != "":
+		return GitHubHostOverride
+	case cfg.GitHubHost != "":
+		return cfg.GitHubHost
+	default:
+		return DefaultGitHubHost
+	}
+}
+
+// tokenAccount returns the credential store key for the current token.
+// Tokens are stored per host (and per profile) so accounts never collide.
+func (cfg *AppConfig) tokenAccount() string {
+	if cfg.Profile == "" {
+		return cfg.Host()
+	}
+	return cfg.Host() + "/" + cfg.Profile
+}
+
+// SelectProfile switches cfg to the named profile: its token, model and port
+// replace the top-level values (unset profile fields keep the top-level value).
+func (cfg *AppConfig) SelectProfile(name string) error {
+	if err := cfg.SelectProfileSettings(name); err != nil {
+		return err
+	}
+
+	cfg.GitHubToken = cfg.Profiles[name].GitHubToken
+	if cfg.CredentialStore != credstore.BackendPlaintext {
+		store, err := credstore.Open(cfg.CredentialStore)
+		if err != nil {
+			return err
 		}
-		if err := SaveConfig(defaultCfg); err != nil {
-			return nil, err
+		token, err := store.Get(cfg.tokenAccount())
+		if err != nil && !errors.Is(err, credstore.ErrNotFound) {
+			return fmt.Errorf("failed to read token for profile %q: %w", name, err)
 		}
-		return defaultCfg, nil
+		cfg.GitHubToken = token
+	}
+	return nil
+}
+
+// SelectProfileSettings is SelectProfile without the token, for a cfg from
+// ReadConfig
+func (cfg *AppConfig) SelectProfileSettings(name string) error {
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
 	}
 
-	data, err := os.ReadFile(configPath)
+	cfg.sources = maps.Clone(cfg.sources)
+	if cfg.sources == nil {
+		cfg.sources = map[string]string{}
+	}
+	source := "profile " + name
+
+	cfg.base = &Profile{
+		Port:         cfg.Port,
+		Model:        cfg.Model,
+		GitHubHost:   cfg.GitHubHost,
+		GitHubAPIURL: cfg.GitHubAPIURL,
+		GitHubToken:  cfg.GitHubToken,
+	}
+	cfg.Profile = name
+	if p.Port != "" && os.Getenv("PROXY_PORT") == "" {
+		cfg.Port = p.Port
+		cfg.sources["port"] = source
+	}
+	if p.Model != "" {
+		cfg.Model = p.Model
+		cfg.sources["model"] = source
+	}
+	if p.GitHubHost != "" {
+		cfg.GitHubHost = p.GitHubHost
+		cfg.GitHubAPIURL = p.GitHubAPIURL
+		cfg.sources["github_host"] = source
+		cfg.sources["github_api_url"] = source
+	}
+	return nil
+}
+
+// ProfileToken returns the stored token of the named profile without selecting it
+func (cfg *AppConfig) ProfileToken(name string) (string, error) {
+	probe := *cfg
+	if err := probe.SelectProfile(name); err != nil {
+		return "", err
+	}
+	return probe.GitHubToken, nil
+}
+
+// RemoveProfile deletes a profile and its token from every credential backend
+func (cfg *AppConfig) RemoveProfile(name string) error {
+	probe := *cfg
+	if err := probe.SelectProfile(name); err != nil {
+		return err
+	}
+	if err := credstore.DeleteAll(probe.tokenAccount()); err != nil {
+		return err
+	}
+	delete(cfg.Profiles, name)
+	return SaveConfig(cfg)
+}
+
+// Source describes where a top-level setting (by JSON key) came from:
+// "file", "env NAME", "profile NAME" or "default"
+func (cfg *AppConfig) Source(key string) string {
+	if source, ok := cfg.sources[key]; ok {
+		return source
+	}
+	return "default"
+}
+
+// FileValue returns the config file value of key in command-line flag syntax
+func (cfg *AppConfig) FileValue(key string) (string, bool) {
+	raw, ok := cfg.raw[key]
+	if !ok || string(raw) == "null" {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	return string(raw), true
+}
+
+// UnknownKeys lists the config file keys that are not part of the schema
+// (usually typos, which would otherwise be silently ignored)
+func (cfg *AppConfig) UnknownKeys() []string {
+	known := map[string]bool{}
+	t := reflect.TypeOf(AppConfig{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	var unknown []string
+	for key := range cfg.raw {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// LoadConfig reads the config or creates a default one
+func LoadConfig() (*AppConfig, error) {
+	cfg, exists, err := readConfigFile()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
+	}
+	if err := resolveToken(cfg, !exists); err != nil {
+		return nil, err
 	}
+	cfg.applyEnvPort()
+	return cfg, nil
+}
 
-	var cfg AppConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
+// ReadConfig reads the config file without the token. Unlike LoadConfig it
+// neither touches the credential store nor writes the file, so it is safe
+// while the proxy is serving (reloads).
+func ReadConfig() (*AppConfig, error) {
+	cfg, _, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+	cfg.applyEnvPort()
+	return cfg, nil
+}
+
+// readConfigFile parses the config file; a missing file is the default config
+func readConfigFile() (cfg *AppConfig, exists bool, err error) {
+	cfg = &AppConfig{}
+	data, err := os.ReadFile(GetConfigPath())
+	switch {
+	case os.IsNotExist(err):
+		cfg.Port = "8080" // Default proxy port
+	case err != nil:
+		return nil, false, fmt.Errorf("failed to read config file: %w", err)
+	default:
+		exists = true
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, false, fmt.Errorf("failed to parse config file: %w", err)
+		}
+		if err := json.Unmarshal(data, &cfg.raw); err != nil {
+			return nil, false, fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+	cfg.sources = map[string]string{}
+	for key := range cfg.raw {
+		cfg.sources[key] = "file"
 	}
+	return cfg, exists, nil
+}
 
-	// Override with env var if available
+// applyEnvPort overrides the port with PROXY_PORT if set
+func (cfg *AppConfig) applyEnvPort() {
 	if port := os.Getenv("PROXY_PORT"); port != "" {
 		cfg.Port = port
+		cfg.sources["port"] = "env PROXY_PORT"
+	}
+}
+
+// resolveToken loads the token from the credential store, migrating a plaintext
+// token from the config file (or from a previously selected backend) on the way.
+func resolveToken(cfg *AppConfig, firstRun bool) error {
+	previous := cfg.CredentialStore
+	if CredentialStoreOverride != "" {
+		cfg.CredentialStore = CredentialStoreOverride
+	}
+	dirty := firstRun || cfg.CredentialStore != previous
+
+	if cfg.CredentialStore == credstore.BackendPlaintext {
+		if cfg.GitHubToken == "" && previous != credstore.BackendPlaintext {
+			cfg.GitHubToken = tokenFrom(previous, cfg.tokenAccount())
+		}
+		if dirty {
+			return SaveConfig(cfg)
+		}
+		return nil
+	}
+
+	store, err := credstore.Open(cfg.CredentialStore)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cfg.GitHubToken != "":
+		// Plaintext token left in the file by an older version
+		if err := store.Set(cfg.tokenAccount(), cfg.GitHubToken); err != nil {
+			return fmt.Errorf("failed to migrate token to %s store: %w", store.Name(), err)
+		}
+		slog.Info("平文のトークンを移行しました", "store", store.Name())
+		dirty = true
+	case cfg.CredentialStore != previous && previous != credstore.BackendPlaintext:
+		if token := tokenFrom(previous, cfg.tokenAccount()); token != "" {
+			if err := store.Set(cfg.tokenAccount(), token); err != nil {
+				return fmt.Errorf("failed to move token to %s store: %w", store.Name(), err)
+			}
+			cfg.GitHubToken = token
+		}
+	default:
+		token, err := store.Get(cfg.tokenAccount())
+		if err != nil && !errors.Is(err, credstore.ErrNotFound) {
+			return fmt.Errorf("failed to read token from %s store: %w", store.Name(), err)
+		}
+		cfg.GitHubToken = token
+	}
+
+	if cfg.CredentialStore == "" || cfg.CredentialStore == credstore.BackendAuto {
+		// Remember what auto picked: on a later run where the keyring does not
+		// answer, auto would fall back to the file and the token would look missing
+		cfg.CredentialStore = store.Name()
+		dirty = true
 	}
 
-	return &cfg, nil
+	if dirty {
+		return SaveConfig(cfg)
+	}
+	return nil
+}
+
+// tokenFrom reads the account's token from the named backend, ignoring failures
+func tokenFrom(backend, account string) string {
+	store, err := credstore.Open(backend)
+	if err != nil {
+		return ""
+	}
+	token, _ := store.Get(account)
+	return token
 }
 
-// SaveConfig writes the given config to the file system
+// SaveConfig writes the given config to the file system.
+// The token goes to the credential store unless the plaintext backend is selected.
 func SaveConfig(cfg *AppConfig) error {
 	configPath := GetConfigPath()
 
@@ -56,7 +413,50 @@ func SaveConfig(cfg *AppConfig) error {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	// token is the active token to persist ("" when it is managed externally)
+	token := cfg.GitHubToken
+	if cfg.externalToken {
+		token = ""
+	}
+
+	// The selected profile's values go back into the profile, not the top level
+	fileCfg := *cfg
+	fileCfg.GitHubToken = token
+	if cfg.Profile != "" {
+		profile := *cfg.Profiles[cfg.Profile]
+		profile.GitHubToken = token
+		fileCfg.Profiles = make(map[string]*Profile, len(cfg.Profiles))
+		for name, p := range cfg.Profiles {
+			fileCfg.Profiles[name] = p
+		}
+		fileCfg.Profiles[cfg.Profile] = &profile
+		fileCfg.Port, fileCfg.Model, fileCfg.GitHubToken = cfg.base.Port, cfg.base.Model, cfg.base.GitHubToken
+		fileCfg.GitHubHost, fileCfg.GitHubAPIURL = cfg.base.GitHubHost, cfg.base.GitHubAPIURL
+	}
+
+	if cfg.CredentialStore != credstore.BackendPlaintext {
+		fileCfg.GitHubToken = ""
+		if token != "" {
+			store, err := credstore.Open(cfg.CredentialStore)
+			if err != nil {
+				return err
+			}
+			if err := store.Set(cfg.tokenAccount(), token); err != nil {
+				return fmt.Errorf("failed to store token in %s store: %w", store.Name(), err)
+			}
+		}
+		if fileCfg.Profiles != nil {
+			stripped := make(map[string]*Profile, len(fileCfg.Profiles))
+			for name, p := range fileCfg.Profiles {
+				copied := *p
+				copied.GitHubToken = ""
+				stripped[name] = &copied
+			}
+			fileCfg.Profiles = stripped
+		}
+	}
+
+	data, err := json.MarshalIndent(fileCfg, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
@@ -68,13 +468,50 @@ func SaveConfig(cfg *AppConfig) error {
 	return nil
 }
 
-// DeleteConfig removes the config file (used by -logoff)
-func DeleteConfig() error {
-	configPath := GetConfigPath()
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return nil // already gone
+// DeleteTokens removes all tokens (including those of profiles) from every
+// credential backend and from the config file, keeping the other settings
+// (used by `claude-copilot logout`)
+func DeleteTokens() error {
+	var cfg AppConfig
+	data, err := os.ReadFile(GetConfigPath())
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("failed to read config file: %w", err)
+	default:
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("failed to parse config file: %w", err)
+		}
+	}
+
+	// Tokens are stored under the host they were created for: the --github-host
+	// override (login --github-host) as well as the configured hosts
+	hosts := []string{cfg.Host()}
+	if GitHubHostOverride != "" {
+		hosts = append(hosts, cmp.Or(cfg.GitHubHost, DefaultGitHubHost))
+	}
+	var accounts []string
+	for _, host := range hosts {
+		accounts = append(accounts, host)
+		for name, p := range cfg.Profiles {
+			accounts = append(accounts, cmp.Or(p.GitHubHost, host)+"/"+name)
+		}
+	}
+	slices.Sort(accounts)
+	for _, account := range slices.Compact(accounts) {
+		if err := credstore.DeleteAll(account); err != nil {
+			return err
+		}
+	}
+
+	if data == nil {
+		return nil // no config file to clean
+	}
+	cfg.GitHubToken = ""
+	for _, p := range cfg.Profiles {
+		p.GitHubToken = ""
 	}
-	return os.Remove(configPath)
+	return SaveConfig(&cfg)
 }
 
 // GetConfigPath returns the path to the config file
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"claude-copilot/config"
+	"claude-copilot/credstore"
+)
+
+// runConfigCommand implements `claude-copilot config show|validate|path`
+func runConfigCommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  claude-copilot config show [FLAGS]      有効な設定と、その設定元を表示")
+		fmt.Println("  claude-copilot config validate [FLAGS]  設定を検証（不明なキー・存在しないパス）")
+		fmt.Println("  claude-copilot config path              設定ファイルのパスを表示")
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	if isHelpArg(args[0]) {
+		usage()
+		return exitOK
+	}
+
+	switch args[0] {
+	case "path":
+		fmt.Println(config.GetConfigPath())
+		return exitOK
+
+	case "show", "validate":
+		// The proxy's own flags, so `config show -debug` previews their effect
+		f := defineServeFlags(flag.NewFlagSet("config "+args[0], flag.ContinueOnError))
+		if code, ok := parseCommandFlags(f.fs, "config "+args[0]+" [FLAGS]", args[1:]); !ok {
+			return code
+		}
+
+		cfg, err := f.loadConfig()
+		if cfg == nil {
+			fmt.Printf("❌ 設定の読み込みに失敗しました: %v\n", err)
+			return exitFailure
+		}
+		if args[0] == "show" {
+			printEffectiveConfig(f, cfg)
+		}
+
+		problems := 0
+		for _, key := range cfg.UnknownKeys() {
+			fmt.Printf("⚠️  不明なキー: %s\n", key)
+			problems++
+		}
+		if err != nil {
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("❌ %s\n", line)
+			}
+			return exitFailure
+		}
+		if args[0] == "validate" {
+			if problems > 0 {
+				return exitFailure // so scripts notice typos in the file
+			}
+			fmt.Printf("✅ 設定に問題はありません: %s\n", config.GetConfigPath())
+		}
+		return exitOK
+
+	default:
+		usage()
+		return exitUsage
+	}
+}
+
+// printEffectiveConfig lists every setting with its effective value and source
+func printEffectiveConfig(f *serveFlags, cfg *config.AppConfig) {
+	fmt.Printf("# %s\n", config.GetConfigPath())
+	fmt.Printf("%-24s %-40s %s\n", "KEY", "VALUE", "SOURCE")
+
+	f.fs.VisitAll(func(fl *flag.Flag) {
+		if transientFlags[fl.Name] {
+			return
+		}
+		fmt.Printf("%-24s %-40s %s\n", optionKey(fl.Name), valueOrDash(f.effectiveValue(cfg, fl.Name)), f.source(cfg, fl.Name))
+	})
+
+	fmt.Printf("%-24s %-40s %s\n", "model_aliases", valueOrDash(formatAliases(cfg.ModelAliases)), cfg.Source("model_aliases"))
+	fmt.Printf("%-24s %-40s %s\n", "key_budgets", valueOrDash(formatKeyBudgets(cfg.KeyBudgets)), cfg.Source("key_budgets"))
+	fmt.Printf("%-24s %-40s %s\n", "redact_patterns", valueOrDash(strings.Join(cfg.RedactPatterns, " ")), cfg.Source("redact_patterns"))
+
+	tokenSource := "credential store (" + f.effectiveValue(cfg, "credential-store") + ")"
+	if cfg.GitHubToken == "" {
+		tokenSource = "-"
+	}
+	fmt.Printf("%-24s %-40s %s\n", "github_token", maskSecret(cfg.GitHubToken), tokenSource)
+
+	if len(cfg.Profiles) > 0 {
+		names, _ := json.Marshal(sortedKeys(cfg.Profiles))
+		fmt.Printf("%-24s %-40s %s\n", "profiles", string(names), "file")
+	}
+}
+
+// effectiveValue returns the value the proxy would use for a flag
+func (f *serveFlags) effectiveValue(cfg *config.AppConfig, name string) string {
+	switch name {
+	case "port":
+		return f.portString(cfg)
+	case "listen":
+		endpoints, err := resolveEndpoints(f.listenValues(cfg), f.portString(cfg))
+		if err != nil {
+			return err.Error()
+		}
+		values := make([]string, len(endpoints))
+		for i, e := range endpoints {
+			values[i] = e.String()
+		}
+		return strings.Join(values, ",")
+	case "model":
+		return f.defaultModel(cfg)
+	case "github-host":
+		return cfg.Host()
+	case "github-api-url":
+		if *f.githubAPIURL != "" {
+			return *f.githubAPIURL
+		}
+		return cfg.GitHubAPIURL
+	case "credential-store":
+		if cfg.CredentialStore == "" {
+			return credstore.BackendAuto
+		}
+		return cfg.CredentialStore
+	case "require-api-key":
+		return strconv.FormatBool(f.apiKeyRequired(cfg))
+	}
+	return f.fs.Lookup(name).Value.String()
+}
+
+// formatAliases renders model aliases as sorted name=model pairs
+func formatAliases(aliases map[string]string) string {
+	pairs := make([]string, 0, len(aliases))
+	for _, name := range sortedKeys(aliases) {
+		pairs = append(pairs, name+"="+aliases[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// formatKeyBudgets formats per-key budgets as name(limit=value ...),...
+func formatKeyBudgets(budgets map[string]*config.Budget) string {
+	entries := make([]string, 0, len(budgets))
+	for _, name := range sortedKeys(budgets) {
+		b := budgets[name]
+		if b == nil {
+			continue
+		}
+		var limits []string
+		for _, l := range []struct {
+			key   string
+			value float64
+		}{{"daily_soft", b.DailySoft}, {"daily_hard", b.DailyHard}, {"monthly_soft", b.MonthlySoft}, {"monthly_hard", b.MonthlyHard}} {
+			if l.value > 0 {
+				limits = append(limits, fmt.Sprintf("%s=%g", l.key, l.value))
+			}
+		}
+		entries = append(entries, name+"("+strings.Join(limits, " ")+")")
+	}
+	return strings.Join(entries, ",")
+}
+
+// maskSecret keeps just enough of a secret to recognize it
+func maskSecret(secret string) string {
+	switch {
+	case secret == "":
+		return "-"
+	case len(secret) <= 8:
+		return "****"
+	default:
+		return secret[:4] + "****" + secret[len(secret)-4:]
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package credstore
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Backend names accepted by Open (and the credential_store config key)
+const (
+	BackendAuto      = "auto"
+	BackendKeyring   = "keyring"
+	BackendFile      = "file"
+	BackendPlaintext = "plaintext"
+)
+
+var (
+	// ErrNotFound is returned by Get when no secret is stored for the account
+	ErrNotFound = errors.New("credential not found")
+	// ErrUnavailable is returned when a backend cannot be used on this machine
+	ErrUnavailable = errors.New("credential backend unavailable")
+)
+
+// Store keeps secrets (GitHub tokens) keyed by account name
+type Store interface {
+	Name() string
+	Get(account string) (string, error)
+	Set(account, secret string) error
+	Delete(account string) error
+}
+
+// Open returns the backend with the given name. "auto" picks the OS keyring when
+// its service answers and falls back to the encrypted file. The plaintext backend is handled
+// by the config file itself, so Open returns ErrUnavailable for it.
+func Open(name string) (Store, error) {
+	switch name {
+	case "", BackendAuto:
+		if k := newKeyring(); k.available() == nil {
+			return k, nil
+		}
+		return newEncryptedFile(), nil
+	case BackendKeyring:
+		k := newKeyring()
+		if err := k.available(); err != nil {
+			return nil, fmt.Errorf("%w: %s (%v)", ErrUnavailable, name, err)
+		}
+		return k, nil
+	case BackendFile:
+		return newEncryptedFile(), nil
+	case BackendPlaintext:
+		return nil, fmt.Errorf("%w: %s is stored in the config file", ErrUnavailable, name)
+	default:
+		return nil, fmt.Errorf("unknown credential store %q (auto, keyring, file, plaintext)", name)
+	}
+}
+
+// DeleteAll removes the account from every backend that is usable on this machine
+func DeleteAll(account string) error {
+	var errs []error
+	if k := newKeyring(); k.available() == nil {
+		if err := k.Delete(account); err != nil {
+			errs = append(errs, fmt.Errorf("keyring: %w", err))
+		}
+	}
+	if err := newEncryptedFile().Delete(account); err != nil {
+		errs = append(errs, fmt.Errorf("file: %w", err))
+	}
+	return errors.Join(errs...)
+}
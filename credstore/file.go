@@ -0,0 +1,203 @@
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// PassphraseEnv, when set, supplies the passphrase for the encrypted file.
+// Without it the key is bound to this machine and user.
+const PassphraseEnv = "CLAUDE_COPILOT_PASSPHRASE"
+
+const (
+	keySourcePassphrase = "passphrase"
+	keySourceMachine    = "machine"
+	pbkdf2Iterations    = 600000
+)
+
+// encryptedFileData is the on-disk format of the encrypted credential file
+type encryptedFileData struct {
+	Version    int    `json:"version"`
+	KeySource  string `json:"key_source"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// encryptedFile stores all accounts in one AES-256-GCM encrypted JSON file
+type encryptedFile struct {
+	path string
+}
+
+func newEncryptedFile() *encryptedFile {
+	return &encryptedFile{path: FilePath()}
+}
+
+// FilePath returns the path of the encrypted credential file
+func FilePath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "." // Fallback
+	}
+	return filepath.Join(homeDir, ".claude_copilot_credentials.enc")
+}
+
+func (f *encryptedFile) Name() string { return BackendFile }
+
+func (f *encryptedFile) Get(account string) (string, error) {
+	secrets, err := f.load()
+	if err != nil {
+		return "", err
+	}
+	secret, ok := secrets[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (f *encryptedFile) Set(account, secret string) error {
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	secrets[account] = secret
+	return f.save(secrets)
+}
+
+func (f *encryptedFile) Delete(account string) error {
+	if _, err := os.Stat(f.path); os.IsNotExist(err) {
+		return nil // already gone
+	}
+	secrets, err := f.load()
+	if err != nil {
+		return err
+	}
+	delete(secrets, account)
+	if len(secrets) == 0 {
+		return os.Remove(f.path)
+	}
+	return f.save(secrets)
+}
+
+func (f *encryptedFile) load() (map[string]string, error) {
+	raw, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential file: %w", err)
+	}
+
+	var data encryptedFileData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, fmt.Errorf("failed to parse credential file: %w", err)
+	}
+	if data.KeySource == keySourcePassphrase && os.Getenv(PassphraseEnv) == "" {
+		return nil, fmt.Errorf("credential file is passphrase-protected: set %s", PassphraseEnv)
+	}
+
+	gcm, err := newGCM(data.KeySource, data.Salt)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, data.Nonce, data.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential file (wrong passphrase or different machine?): %w", err)
+	}
+
+	secrets := map[string]string{}
+	if err := json.Unmarshal(plain, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse decrypted credentials: %w", err)
+	}
+	return secrets, nil
+}
+
+func (f *encryptedFile) save(secrets map[string]string) error {
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+
+	keySource := keySourceMachine
+	if os.Getenv(PassphraseEnv) != "" {
+		keySource = keySourcePassphrase
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(keySource, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	raw, err := json.MarshalIndent(encryptedFileData{
+		Version:    1,
+		KeySource:  keySource,
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, plain, nil),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create credential directory: %w", err)
+	}
+	if err := os.WriteFile(f.path, raw, 0600); err != nil {
+		return fmt.Errorf("failed to write credential file: %w", err)
+	}
+	return nil
+}
+
+func newGCM(keySource string, salt []byte) (cipher.AEAD, error) {
+	secret := os.Getenv(PassphraseEnv)
+	if keySource == keySourceMachine {
+		secret = machineSecret()
+	}
+
+	key, err := pbkdf2.Key(sha256.New, secret, salt, pbkdf2Iterations, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// machineSecret combines identifiers that are stable for this machine and user,
+// so the file cannot be decrypted after being copied elsewhere.
+func machineSecret() string {
+	parts := []string{"claude-copilot"}
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if id, err := os.ReadFile(path); err == nil {
+			parts = append(parts, strings.TrimSpace(string(id)))
+			break
+		}
+	}
+	if host, err := os.Hostname(); err == nil {
+		parts = append(parts, host)
+	}
+	if u, err := user.Current(); err == nil {
+		parts = append(parts, u.Uid, u.Username)
+	}
+	return strings.Join(parts, "\x00")
+}
@@ -0,0 +1,128 @@
+package credstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const keyringService = "claude-copilot"
+
+// probeAccount is looked up to check that the keyring service answers
+const probeAccount = "claude-copilot-probe"
+
+// securityItemNotFound is the exit status of `security` when the item does not exist
+const securityItemNotFound = 44
+
+// keyring stores secrets in the OS keyring by driving the platform tool:
+// secret-tool (Secret Service) on Linux/BSD and security (Keychain) on macOS.
+type keyring struct {
+	tool string
+}
+
+func newKeyring() *keyring {
+	tool := "secret-tool"
+	if runtime.GOOS == "darwin" {
+		tool = "security"
+	}
+	return &keyring{tool: tool}
+}
+
+func (k *keyring) Name() string { return BackendKeyring }
+
+// available checks that the keyring tool is installed and that the service
+// behind it answers (a headless or SSH session often has secret-tool but no
+// Secret Service running)
+func (k *keyring) available() error {
+	if runtime.GOOS == "windows" {
+		return errors.New("no keyring support on windows")
+	}
+	if _, err := exec.LookPath(k.tool); err != nil {
+		return fmt.Errorf("no keyring tool found: %w", err)
+	}
+	if _, err := k.Get(probeAccount); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (k *keyring) Get(account string) (string, error) {
+	var cmd *exec.Cmd
+	if k.tool == "security" {
+		cmd = exec.Command(k.tool, "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command(k.tool, "lookup", "service", keyringService, "account", account)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	secret := strings.TrimRight(string(out), "\r\n")
+	if err != nil {
+		// A missing item is a plain non-zero exit (status 44 for security, no
+		// message for secret-tool); anything else means the tool itself failed
+		var exitErr *exec.ExitError
+		msg := strings.TrimSpace(stderr.String())
+		if errors.As(err, &exitErr) {
+			if k.tool == "security" && exitErr.ExitCode() == securityItemNotFound {
+				return "", ErrNotFound
+			}
+			if k.tool != "security" && msg == "" {
+				return "", ErrNotFound
+			}
+		}
+		return "", fmt.Errorf("%s failed: %w: %s", k.tool, err, msg)
+	}
+	if secret == "" {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (k *keyring) Set(account, secret string) error {
+	var cmd *exec.Cmd
+	if k.tool == "security" {
+		// Interactive mode reads the command from stdin, keeping the secret off argv
+		cmd = exec.Command(k.tool, "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+			securityQuote(keyringService), securityQuote(account), securityQuote(secret)))
+	} else {
+		cmd = exec.Command(k.tool, "store", "--label", "claude-copilot GitHub token ("+account+")",
+			"service", keyringService, "account", account)
+		cmd.Stdin = strings.NewReader(secret)
+	}
+	return run(cmd)
+}
+
+// securityQuote quotes an argument for the command line of `security -i`
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (k *keyring) Delete(account string) error {
+	if _, err := k.Get(account); err == ErrNotFound {
+		return nil // already gone
+	} else if err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	if k.tool == "security" {
+		cmd = exec.Command(k.tool, "delete-generic-password", "-s", keyringService, "-a", account)
+	} else {
+		cmd = exec.Command(k.tool, "clear", "service", keyringService, "account", account)
+	}
+	return run(cmd)
+}
+
+func run(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w: %s", cmd.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
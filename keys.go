@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"claude-copilot/apikeys"
+)
+
+// runKeysCommand implements `claude-copilot keys create|list|revoke`
+func runKeysCommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  claude-copilot keys create [-name NAME]")
+		fmt.Println("  claude-copilot keys list")
+		fmt.Println("  claude-copilot keys revoke ID|NAME")
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	if isHelpArg(args[0]) {
+		usage()
+		return exitOK
+	}
+
+	store, err := apikeys.Open(apikeys.DefaultPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ APIキーの読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("keys create", flag.ContinueOnError)
+		name := fs.String("name", "", "キーの名前（利用者や用途）")
+		if code, ok := parseCommandFlags(fs, "keys create [FLAGS]", args[1:]); !ok {
+			return code
+		}
+
+		secret, key, err := store.Create(*name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ APIキーの作成に失敗しました: %v\n", err)
+			return exitFailure
+		}
+		fmt.Printf("✅ APIキーを作成しました (id: %s)\n", key.ID)
+		fmt.Println("   このキーは再表示できません。安全な場所に保存してください:")
+		fmt.Printf("\n   %s\n\n", secret)
+		fmt.Println("   Claude Code では ANTHROPIC_AUTH_TOKEN に設定します。")
+		return exitOK
+
+	case "list":
+		keys, err := store.List()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		if len(keys) == 0 {
+			fmt.Println("APIキーはありません（claude-copilot keys create で作成）")
+			return exitOK
+		}
+		fmt.Printf("%-10s %-16s %-10s %-20s %s\n", "ID", "NAME", "KEY", "CREATED", "STATUS")
+		for _, key := range keys {
+			status := "active"
+			if key.RevokedAt != nil {
+				status = "revoked " + key.RevokedAt.Format("2006-01-02 15:04")
+			}
+			fmt.Printf("%-10s %-16s %-10s %-20s %s\n", key.ID, valueOrDash(key.Name), key.Hint+"…",
+				key.CreatedAt.Format("2006-01-02 15:04"), status)
+		}
+		return exitOK
+
+	case "revoke":
+		if len(args) < 2 {
+			usage()
+			return exitUsage
+		}
+		key, err := store.Revoke(args[1])
+		if errors.Is(err, apikeys.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "❌ 有効なAPIキー %q が見つかりません\n", args[1])
+			return exitFailure
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		fmt.Printf("✅ APIキーを無効化しました (id: %s)\n", key.ID)
+		return exitOK
+
+	default:
+		usage()
+		return exitUsage
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"claude-copilot/tlscert"
+)
+
+// defaultListenHost keeps the proxy reachable from this machine only
+const defaultListenHost = "127.0.0.1"
+
+// unixScheme prefixes Unix domain socket listen addresses
+const unixScheme = "unix://"
+
+// listenFlag collects --listen values; the flag may be repeated or comma-separated
+type listenFlag []string
+
+func (f *listenFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *listenFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
+	return nil
+}
+
+// endpoint is a resolved listen address
+type endpoint struct {
+	network string // "tcp" or "unix"
+	address string // host:port or socket path
+}
+
+func (e endpoint) String() string {
+	if e.network == "unix" {
+		return unixScheme + e.address
+	}
+	return e.address
+}
+
+// resolveEndpoints turns --listen values into endpoints. No value means the
+// loopback address on port; a bare host gets port.
+func resolveEndpoints(values []string, port string) ([]endpoint, error) {
+	if len(values) == 0 {
+		return []endpoint{{"tcp", net.JoinHostPort(defaultListenHost, port)}}, nil
+	}
+
+	var endpoints []endpoint
+	for _, value := range values {
+		if path, ok := strings.CutPrefix(value, unixScheme); ok {
+			if path == "" {
+				return nil, fmt.Errorf("invalid listen address %q (expected unix:///path/to.sock)", value)
+			}
+			endpoints = append(endpoints, endpoint{"unix", path})
+			continue
+		}
+		addr, err := resolveListenAddr(value, port)
+		if err != nil {
+			return nil, err
+		}
+		endpoints = append(endpoints, endpoint{"tcp", addr})
+	}
+	return endpoints, nil
+}
+
+// resolveListenAddr returns the host:port to listen on. A bare host gets the
+// configured port.
+func resolveListenAddr(listen, port string) (string, error) {
+	if _, _, err := net.SplitHostPort(listen); err == nil {
+		return listen, nil
+	}
+	if net.ParseIP(listen) != nil || !strings.Contains(listen, ":") {
+		return net.JoinHostPort(listen, port), nil
+	}
+	return "", fmt.Errorf("invalid listen address %q (expected host:port)", listen)
+}
+
+// tcpAddrs returns the host:port of every TCP endpoint
+func tcpAddrs(endpoints []endpoint) []string {
+	var addrs []string
+	for _, e := range endpoints {
+		if e.network == "tcp" {
+			addrs = append(addrs, e.address)
+		}
+	}
+	return addrs
+}
+
+// isLoopback reports whether addr only accepts connections from this machine
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// baseURL returns the URL clients on this machine use to reach addr
+func baseURL(addr string, useTLS bool) string {
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return scheme + "://" + addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// clientBaseURL returns the URL Claude Code should use: the first TCP endpoint,
+// or the socat shim's port when the proxy only listens on Unix sockets
+func clientBaseURL(endpoints []endpoint, useTLS bool, port string) string {
+	if addrs := tcpAddrs(endpoints); len(addrs) > 0 {
+		return baseURL(addrs[0], useTLS)
+	}
+	return "http://localhost:" + port
+}
+
+// loadTLSConfig builds the server TLS config from --tls-cert/--tls-key or, with
+// --tls-self-signed, from a generated certificate covering the hosts in addrs.
+// It returns nil when TLS is not requested.
+func loadTLSConfig(certFile, keyFile string, selfSigned bool, addrs []string) (*tls.Config, string, error) {
+	switch {
+	case certFile != "" || keyFile != "":
+		if certFile == "" || keyFile == "" {
+			return nil, "", fmt.Errorf("--tls-cert and --tls-key must be given together")
+		}
+	case selfSigned:
+		var err error
+		certFile, keyFile, err = tlscert.SelfSigned(tlscert.DefaultDir(), certHosts(addrs))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+	default:
+		return nil, "", nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, certFile, nil
+}
+
+// certHosts lists the names a self-signed certificate for addrs should cover
+func certHosts(addrs []string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, name)
+	}
+	for _, addr := range addrs {
+		if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+			if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	return hosts
+}
+
+// newListener listens on e. TCP endpoints serve TLS when tlsConfig is set;
+// Unix sockets are local-only and always plain HTTP.
+func newListener(e endpoint, tlsConfig *tls.Config) (net.Listener, error) {
+	if e.network == "unix" {
+		return listenUnix(e.address)
+	}
+	listener, err := net.Listen("tcp", e.address)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	return listener, nil
+}
+
+// newListeners opens every endpoint, closing the ones already opened on failure
+func newListeners(endpoints []endpoint, tlsConfig *tls.Config) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, e := range endpoints {
+		listener, err := newListener(e, tlsConfig)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", e, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a Unix domain socket accessible to the current user only.
+// A socket left behind by a crashed proxy is removed; one still in use is not.
+func listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Bind in a private (0700) directory and move the socket into place once it
+	// is 0600, so other users can never connect in between. Changing the umask
+	// instead would affect the files other goroutines create meanwhile.
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".sock-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "s")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmp, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	listener.SetUnlinkOnClose(false) // the socket is no longer at tmp
+	if err = os.Chmod(tmp, 0600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: listener, path: path}, nil
+}
+
+// unixListener is a socket moved to path after binding: it reports path as its
+// address and removes it on Close
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixListener) Addr() net.Addr {
+	return &net.UnixAddr{Name: l.path, Net: "unix"}
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	os.Remove(l.path)
+	return err
+}
@@ -0,0 +1,157 @@
+// Package logging sets up the proxy's structured logger (log/slog) and carries
+// request IDs through contexts so every log line of a request can be correlated.
+// Secrets are masked in every message and attribute before they are written.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	"claude-copilot/redact"
+	"claude-copilot/tracing"
+)
+
+// Formats are the accepted values of -log-format
+var Formats = []string{"text", "json"}
+
+// Level is the minimum level written. It can be changed while the proxy runs.
+var Level = new(slog.LevelVar)
+
+// redactor masks log records; secrets are masked until SetRedactor is called
+var redactor atomic.Pointer[redact.Redactor]
+
+func init() {
+	r, _ := redact.New(redact.Secrets)
+	redactor.Store(r)
+}
+
+// SetRedactor replaces the redactor applied to every log record (nil masks nothing)
+func SetRedactor(r *redact.Redactor) {
+	redactor.Store(r)
+}
+
+// ParseLevel parses a -log-level value (debug, info, warn or error)
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	switch strings.ToLower(s) {
+	case "debug":
+		level = slog.LevelDebug
+	case "info", "":
+		level = slog.LevelInfo
+	case "warn", "warning":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		return level, fmt.Errorf("unknown log level %q (debug, info, warn or error)", s)
+	}
+	return level, nil
+}
+
+// Setup makes a logger writing to w in format ("text" or "json") the default
+// one. The standard log package is routed through it as well.
+func Setup(format string, w io.Writer) error {
+	opts := &slog.HandlerOptions{Level: Level}
+	var h slog.Handler
+	switch format {
+	case "text", "":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q (text or json)", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{h}))
+	return nil
+}
+
+type requestIDKey struct{}
+
+// NewRequestID returns a new random request ID in the style of Anthropic's
+func NewRequestID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "req_" + hex.EncodeToString(b)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// contextHandler adds the request (and trace) ID of the context to every record and masks
+// secrets in its message and attributes
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if red := redactor.Load(); red.Enabled() {
+		masked := slog.NewRecord(r.Time, r.Level, red.String(r.Message), r.PC)
+		r.Attrs(func(a slog.Attr) bool {
+			masked.AddAttrs(redactAttr(red, a))
+			return true
+		})
+		r = masked
+	}
+	if id := RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if id := tracing.TraceID(ctx); id != "" {
+		r.AddAttrs(slog.String("trace_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if red := redactor.Load(); red.Enabled() {
+		masked := make([]slog.Attr, len(attrs))
+		for i, a := range attrs {
+			masked[i] = redactAttr(red, a)
+		}
+		attrs = masked
+	}
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr masks strings, errors and raw JSON (such as debug request dumps),
+// descending into groups
+func redactAttr(red *redact.Redactor, a slog.Attr) slog.Attr {
+	a.Value = a.Value.Resolve()
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(red.String(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		masked := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			masked[i] = redactAttr(red, ga)
+		}
+		a.Value = slog.GroupValue(masked...)
+	case slog.KindAny:
+		switch v := a.Value.Any().(type) {
+		case json.RawMessage:
+			a.Value = slog.AnyValue(json.RawMessage(red.JSON(v)))
+		case error:
+			a.Value = slog.StringValue(red.String(v.Error()))
+		}
+	}
+	return a
+}
@@ -2,162 +2,127 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	copilot "github.com/github/copilot-sdk/go"
 
 	"claude-copilot/api"
+	"claude-copilot/apikeys"
+	"claude-copilot/audit"
 	"claude-copilot/auth"
+	"claude-copilot/cache"
 	"claude-copilot/config"
+	"claude-copilot/logging"
+	"claude-copilot/metrics"
+	"claude-copilot/redact"
+	"claude-copilot/tlscert"
+	"claude-copilot/tracing"
+	"claude-copilot/usage"
 )
 
-func main() {
-	// CLI arguments
-	port := flag.Int("port", 0, "ポート番号 (デフォルト: 8080、環境変数 PROXY_PORT でも指定可)")
-	logoff := flag.Bool("logoff", false, "認証情報を削除してログアウト")
-	debug := flag.Bool("debug", false, "詳細なデバッグログ（プロンプトの中身など）を出力する")
-	insecure := flag.Bool("insecure", false, "プロキシ環境などで TLS 証明書検証をスキップする（NODE_TLS_REJECT_UNAUTHORIZED=0）")
-	caCert := flag.String("ca-cert", "", "追加のCA証明書ファイルパス（NODE_EXTRA_CA_CERTS に設定）")
-	copilotCLIPath := flag.String("copilot-cli", "", "Copilot CLI のパス（環境変数 COPILOT_CLI_PATH でも指定可）")
-	nodeOptions := flag.String("node-options", "", "Node.js の追加オプション（NODE_OPTIONS に設定）")
-	nodePath := flag.String("node-path", "", "Node.js のモジュールパス（NODE_PATH に設定）")
-	nodeBin := flag.String("node-bin", "", "Node.js のbinディレクトリをPATHの先頭に追加")
-	cliInstallVerbose := flag.Bool("cli-install-verbose", false, "埋め込みCLIのインストールログを詳細化（COPILOT_CLI_INSTALL_VERBOSE=1）")
-	sdkDebug := flag.Bool("sdk-debug", false, "Copilot SDK のログレベルを debug に設定")
-	cliStderr := flag.String("cli-stderr", "", "Copilot CLI のstderrを保存するファイルパス")
-	flag.Parse()
-
-	// Handle -logoff
-	if *logoff {
-		if err := config.DeleteConfig(); err != nil {
-			fmt.Printf("❌ 設定ファイルの削除に失敗しました: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Printf("✅ 認証情報を削除しました: %s\n", config.GetConfigPath())
-		os.Exit(0)
-	}
+// version is set at build time (-ldflags "-X main.version=...")
+var version = "dev"
 
-	fmt.Println("Starting Copilot Proxy (Official SDK version)...")
+func main() {
+	os.Exit(runCommand(os.Args[1:]))
+}
 
-	// 1. Load Configuration
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+// runServeCommand implements `claude-copilot serve` (also the default command)
+func runServeCommand(args []string) int {
+	f := defineServeFlags(flag.NewFlagSet("serve", flag.ContinueOnError))
+	if code, ok := parseCommandFlags(f.fs, "serve [FLAGS]", args); !ok {
+		return code
 	}
 
-	// 2. Propagate --insecure to auth package (for Go HTTP requests)
-	if *insecure {
-		auth.Insecure = true
+	// -logoff predates `claude-copilot logout`
+	if *f.logoff {
+		var logoutArgs []string
+		if *f.githubHost != "" {
+			logoutArgs = []string{"-github-host", *f.githubHost}
+		}
+		return runLogoutCommand(logoutArgs)
 	}
 
-	// 3. Ensure GitHub Copilot authentication (Device Auth flow)
-	if err := auth.EnsureToken(cfg); err != nil {
-		log.Fatalf("Authentication failed: %v", err)
-	}
+	startedAt := time.Now()
+	fmt.Printf("Starting Copilot Proxy %s (Official SDK version)...\n", version)
 
-	// 4. Build Copilot SDK ClientOptions
-	opts := &copilot.ClientOptions{
-		GitHubToken: cfg.GitHubToken, // Pass our device-auth token to SDK
+	// 1. Load Configuration (flag > environment > config file > default)
+	cfg, err := f.loadConfig()
+	if err != nil {
+		slog.Error("設定が正しくありません", "error", err)
+		return exitFailure
 	}
-	if *copilotCLIPath != "" {
-		opts.CLIPath = *copilotCLIPath
+	logging.Level.Set(f.minLogLevel())
+	logging.Setup(*f.logFormat, os.Stderr) // the format was validated by loadConfig
+	logging.SetRedactor(f.redactor(cfg, redact.Secrets))
+	for _, key := range cfg.UnknownKeys() {
+		slog.Warn("設定ファイルの不明なキーを無視します", "key", key)
 	}
-	if *sdkDebug {
-		opts.LogLevel = "debug"
+	if *f.profile != "" {
+		fmt.Printf("👤 Profile: %s\n", *f.profile)
 	}
 
-	// Build environment variables for the embedded CLI process
-	cliEnv := os.Environ()
-
-	// Show proxy configuration (mask credentials)
-	hasProxy := false
-	for _, key := range []string{"HTTPS_PROXY", "HTTP_PROXY", "NO_PROXY", "https_proxy", "http_proxy", "no_proxy"} {
-		if v := os.Getenv(key); v != "" {
-			hasProxy = true
-			fmt.Printf("🌐 Proxy: %s=%s\n", key, sanitizeProxyValue(v))
-		}
+	// 2. Propagate TLS, host and token source options to the auth package
+	f.configureAuth(cfg)
+	if auth.GitHubHost != config.DefaultGitHubHost {
+		fmt.Printf("🏢 GitHub host: %s\n", auth.GitHubHost)
 	}
 
-	// --insecure: Skip TLS certificate verification for the embedded Node.js CLI
-	// (useful when corporate proxy performs SSL interception)
-	if *insecure || os.Getenv("NODE_TLS_REJECT_UNAUTHORIZED") == "0" {
-		cliEnv = append(cliEnv, "NODE_TLS_REJECT_UNAUTHORIZED=0")
-		fmt.Println("⚠️  TLS証明書検証を無効化しています (--insecure)")
-	} else if hasProxy {
-		fmt.Println("💡 プロキシ環境でTLSエラーが発生する場合は --insecure オプションを試してください")
-		fmt.Println("   より安全な方法: --ca-cert /path/to/corporate-ca.pem")
-	}
-
-	// --ca-cert or NODE_EXTRA_CA_CERTS: Add custom CA certificate
-	if *caCert != "" {
-		cliEnv = setEnvValue(cliEnv, "NODE_EXTRA_CA_CERTS", *caCert)
-		fmt.Printf("🔐 CA証明書を追加: %s\n", *caCert)
-	} else if v := os.Getenv("NODE_EXTRA_CA_CERTS"); v != "" {
-		fmt.Printf("🔐 CA証明書 (env): %s\n", v)
+	// Determine port: CLI flag > env var > config file > default
+	// (needed before authentication, since the /login page is served on it)
+	portStr := f.portString(cfg)
+	endpoints, err := resolveEndpoints(f.listenValues(cfg), portStr)
+	if err != nil {
+		slog.Error("--listen の値が正しくありません", "error", err)
+		return exitFailure
 	}
-
-	if *cliInstallVerbose {
-		cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_INSTALL_VERBOSE", "1")
-		fmt.Println("🧩 Copilot CLI install verbose enabled")
+	tlsConfig, certFile, err := loadTLSConfig(*f.tlsCert, *f.tlsKey, *f.tlsSelfSigned, tcpAddrs(endpoints))
+	if err != nil {
+		slog.Error("TLS の設定に失敗しました", "error", err)
+		return exitFailure
 	}
 
-	// Copilot CLI path override (flag > env)
-	if *copilotCLIPath != "" {
-		cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_PATH", *copilotCLIPath)
-		fmt.Printf("🧭 Copilot CLI path: %s\n", *copilotCLIPath)
-	} else if v := os.Getenv("COPILOT_CLI_PATH"); v != "" {
-		cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_PATH", v)
-		fmt.Printf("🧭 Copilot CLI path (env): %s\n", v)
+	// 3. Ensure GitHub Copilot authentication (Device Auth flow).
+	// Ctrl-C aborts the flow; the /login page shows the code while it runs.
+	authCtx, stopAuth := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	stopLoginPage := func() {}
+	if !*f.noDeviceFlow {
+		stopLoginPage = serveLoginPage(endpoints, tlsConfig)
 	}
-
-	// Capture CLI stderr if requested (requires explicit CLI path)
-	if *cliStderr != "" {
-		resolvedCLIPath := *copilotCLIPath
-		if resolvedCLIPath == "" {
-			resolvedCLIPath = os.Getenv("COPILOT_CLI_PATH")
-		}
-		if resolvedCLIPath == "" {
-			fmt.Println("⚠️  --cli-stderr を指定する場合は --copilot-cli も指定してください")
-		} else {
-			wrapperPath, err := createCLIWrapper(resolvedCLIPath, *cliStderr)
-			if err != nil {
-				fmt.Printf("⚠️  CLI stderr wrapper 作成に失敗: %v\n", err)
-			} else {
-				opts.CLIPath = wrapperPath
-				cliEnv = setEnvValue(cliEnv, "COPILOT_CLI_PATH", wrapperPath)
-				fmt.Printf("🧾 CLI stderr: %s\n", *cliStderr)
-			}
-		}
+	err = auth.EnsureToken(authCtx, cfg)
+	stopLoginPage()
+	stopAuth()
+	if err != nil {
+		slog.Error("認証に失敗しました", "error", err)
+		return exitFailure
 	}
 
-	// Node.js runtime overrides
-	if *nodeOptions != "" {
-		cliEnv = setEnvValue(cliEnv, "NODE_OPTIONS", *nodeOptions)
-		fmt.Println("🧪 NODE_OPTIONS set")
-	}
-	if *nodePath != "" {
-		cliEnv = setEnvValue(cliEnv, "NODE_PATH", *nodePath)
-		fmt.Printf("🧭 NODE_PATH: %s\n", *nodePath)
-	}
-	if *nodeBin != "" {
-		pathValue := *nodeBin + string(os.PathListSeparator) + os.Getenv("PATH")
-		cliEnv = setEnvValue(cliEnv, "PATH", pathValue)
-		fmt.Printf("🧭 PATH (prepend): %s\n", *nodeBin)
-	}
+	// Keep a Copilot session token fresh in the background
+	tokenSource := auth.NewTokenSource(cfg.GitHubToken)
+	tokenSource.OnRefresh = countTokenRefresh
+	tokenSource.Start()
+	defer tokenSource.Stop()
 
-	opts.Env = cliEnv
+	// 4. Build Copilot SDK ClientOptions
+	opts, hasProxy, cleanup := buildClientOptions(f, cfg, os.Stdout)
+	defer cleanup()
 
 	client := copilot.NewClient(opts)
 	ctx := context.Background()
 	if err := client.Start(ctx); err != nil {
-		fmt.Println("\n❌ Copilot CLI の起動に失敗しました。")
+		slog.Error("Copilot CLI を起動できません", "error", err)
 		if hasProxy {
 			fmt.Println("\n📋 プロキシ環境での対処法:")
 			fmt.Println("  1. --insecure フラグを付けて再実行:")
@@ -167,17 +132,16 @@ func main() {
 			fmt.Println("  3. 環境変数で指定:")
 			fmt.Println("     NODE_TLS_REJECT_UNAUTHORIZED=0", os.Args[0])
 		}
-		log.Fatalf("Failed to start embedded Copilot CLI: %v", err)
+		return exitFailure
 	}
 	defer client.Stop()
 
 	// 5. Verify auth status
 	authStatus, err := client.GetAuthStatus(ctx)
 	if err != nil {
-		fmt.Printf("⚠️  認証状態の確認に失敗: %v\n", err)
+		slog.Warn("認証状態の確認に失敗しました", "error", err)
 	} else if !authStatus.IsAuthenticated {
-		fmt.Println("⚠️  認証されていません。トークンが期限切れの可能性があります。")
-		fmt.Println("   -logoff で一度ログアウトしてから再起動してください。")
+		slog.Warn("認証されていません。トークンが期限切れの可能性があります（claude-copilot logout で一度ログアウトしてから再起動してください）")
 	} else {
 		fmt.Println("✅ GitHub Copilot 認証OK")
 	}
@@ -185,11 +149,133 @@ func main() {
 	// 6. Setup HTTP API Handlers
 	handler := &api.Handler{
 		CopilotClient: client,
-		Debug:         *debug,
+		Limiter:       api.NewLimiter(*f.maxConcurrent, *f.maxQueued),
+		Health: api.HealthInfo{
+			Version:     version,
+			StartedAt:   startedAt,
+			TokenExpiry: tokenSource.ExpiresAt,
+		},
+	}
+	handler.UpdateSettings(f.handlerSettings(cfg))
+	handler.RegisterMetrics()
+	if *f.maxConcurrent > 0 {
+		fmt.Printf("🚦 Max concurrent requests: %d (queue: %d)\n", *f.maxConcurrent, *f.maxQueued)
+	}
+
+	var profiles *profileClients
+	if *f.profileHeader {
+		// Per-profile clients share every CLI option except the token
+		profiles = newProfileClients(cfg, *opts)
+		defer profiles.StopAll()
+		handler.ProfileClient = profiles.Get
+		fmt.Printf("👥 Profile header enabled (%s)\n", api.ProfileHeader)
+	}
+
+	if *f.cacheEnabled {
+		responseCache, err := cache.New(cache.Options{
+			TTL:        *f.cacheTTL,
+			MaxEntries: *f.cacheMaxEntries,
+			MaxBytes:   *f.cacheMaxBytes,
+			Dir:        *f.cacheDir,
+		})
+		if err != nil {
+			slog.Error("レスポンスキャッシュを初期化できません", "error", err)
+			return exitFailure
+		}
+		handler.Cache = responseCache
+		fmt.Printf("🗃️  Response cache enabled (ttl=%s, entries=%d)\n", *f.cacheTTL, responseCache.Len())
+	}
+
+	if *f.auditLog != "" {
+		auditLog, err := audit.Open(audit.Options{
+			Path:           *f.auditLog,
+			MaxBytes:       *f.auditMaxSize,
+			MaxAge:         *f.auditMaxAge,
+			MaxBackups:     *f.auditMaxBackups,
+			IncludeContent: *f.auditPrompts,
+			Redactor:       f.redactor(cfg, *f.auditRedact),
+		})
+		if err != nil {
+			slog.Error("監査ログを開けません", "error", err)
+			return exitFailure
+		}
+		defer auditLog.Close()
+		handler.Audit = auditLog
+		fmt.Printf("📝 Audit log: %s\n", *f.auditLog)
+		if *f.auditPrompts {
+			fmt.Printf("   プロンプトと応答の本文も記録します（マスク: %s）\n", valueOrDash(*f.auditRedact))
+		}
+	}
+
+	if *f.usage {
+		usageStore, err := usage.Open(usage.DefaultPath())
+		if err != nil {
+			slog.Error("利用量を読み込めません", "error", err)
+			return exitFailure
+		}
+		defer usageStore.Close()
+		handler.Usage = usageStore
+		go loadMultipliers(client, usageStore)
+	}
+
+	if *f.traceEndpoint != "" || *f.traceFile != "" {
+		err := tracing.Enable(tracing.Options{
+			Endpoint:       *f.traceEndpoint,
+			File:           *f.traceFile,
+			ServiceVersion: version,
+		})
+		if err != nil {
+			slog.Error("トレースを有効化できません", "error", err)
+			return exitFailure
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+			defer cancel()
+			tracing.Shutdown(ctx)
+		}()
+		if *f.traceEndpoint != "" {
+			fmt.Printf("🔭 Tracing: %s (OTLP/HTTP)\n", *f.traceEndpoint)
+		} else {
+			fmt.Printf("🔭 Tracing: %s\n", *f.traceFile)
+		}
+	}
+
+	// The key check can be switched on and off by reloading the config
+	keys, err := apikeys.Open(apikeys.DefaultPath())
+	if err != nil {
+		slog.Error("APIキーを読み込めません", "error", err)
+		return exitFailure
+	}
+	if f.apiKeyRequired(cfg) {
+		if !keys.Active() {
+			slog.Warn("有効なAPIキーがありません（" + os.Args[0] + " keys create で作成してください）")
+		}
+		fmt.Println("🔒 Inbound API key authentication enabled")
+	}
+	messagesHandler := handler.WithAPIKeys(keys, http.HandlerFunc(handler.HandleMessages))
+
+	// Reload reloadable settings on SIGHUP or when the config file changes
+	// SIGINT / SIGTERM start a graceful shutdown
+	shutdownCtx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	reloader := newReloader(args, f, cfg, handler)
+	go reloader.run(shutdownCtx)
+	go superviseCLI(shutdownCtx, func() map[string]*copilot.Client {
+		clients := profiles.Started()
+		clients[""] = client
+		return clients
+	})
+	if handler.Usage != nil {
+		go handler.Usage.Run(shutdownCtx)
 	}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/messages", handler.HandleMessages)
+	mux.Handle("/v1/messages", messagesHandler)
+	mux.HandleFunc("/login", api.HandleLogin)
+	mux.HandleFunc("GET /healthz", handler.HandleHealthz)
+	mux.HandleFunc("GET /readyz", handler.HandleReadyz)
+	mux.Handle("GET /metrics", metrics.Handler())
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -200,26 +286,135 @@ func main() {
 		w.Write([]byte("Copilot Proxy is running"))
 	})
 
-	// 7. Determine port: CLI flag > env var > config file > default
-	portStr := fmt.Sprintf("%d", *port)
-	if *port == 0 {
-		portStr = cfg.Port
-		if portStr == "" {
-			portStr = "8080"
+	if tlsConfig != nil {
+		if fingerprint, err := tlscert.Fingerprint(certFile); err == nil {
+			fmt.Printf("🔏 TLS certificate: %s\n", certFile)
+			fmt.Printf("   SHA-256 fingerprint: %s\n", fingerprint)
+		}
+	}
+	for _, addr := range tcpAddrs(endpoints) {
+		if !isLoopback(addr) {
+			if f.apiKeyRequired(cfg) {
+				slog.Warn("同じネットワークの他のホストからアクセスできるアドレスで待ち受けています", "addr", addr)
+			} else {
+				slog.Warn("同じネットワークの他のホストからアクセスできるアドレスで待ち受けています（共有する場合は --require-api-key の併用を推奨します）", "addr", addr)
+			}
 		}
 	}
-	addr := ":" + portStr
 
-	fmt.Printf("🚀 Server is running on http://localhost%s\n", addr)
+	listeners, err := newListeners(endpoints, tlsConfig)
+	if err != nil {
+		slog.Error("待ち受けを開始できません", "error", err)
+		return exitFailure
+	}
+
+	// Claude Code needs an http(s) URL; a socket-only proxy is reached through a shim
+	for _, e := range endpoints {
+		if e.network == "unix" {
+			fmt.Printf("🚀 Server is running on %s\n", e)
+			fmt.Printf("   TCP shim: socat TCP-LISTEN:%s,bind=127.0.0.1,reuseaddr,fork UNIX-CONNECT:%s\n", portStr, e.address)
+			continue
+		}
+		fmt.Printf("🚀 Server is running on %s\n", baseURL(e.address, tlsConfig != nil))
+	}
+	serverURL := clientBaseURL(endpoints, tlsConfig != nil, portStr)
 	fmt.Printf("Configure Claude Code:\n")
-	fmt.Printf("    ANTHROPIC_AUTH_TOKEN=dummy \\\n")
-	fmt.Printf("    ANTHROPIC_BASE_URL=\"http://localhost%s\" \\\n", addr)
+	if f.apiKeyRequired(cfg) {
+		fmt.Printf("    ANTHROPIC_AUTH_TOKEN=<API key> \\\n")
+	} else {
+		fmt.Printf("    ANTHROPIC_AUTH_TOKEN=dummy \\\n")
+	}
+	if *f.tlsSelfSigned && *f.tlsCert == "" {
+		fmt.Printf("    NODE_EXTRA_CA_CERTS=%s \\\n", certFile)
+	}
+	fmt.Printf("    ANTHROPIC_BASE_URL=\"%s\" \\\n", serverURL)
 	fmt.Printf("    CLAUDE_CONFIG_DIR=~/.claude_copilot \\\n")
-	fmt.Printf("    claude --model \"GPT-5 mini\"\n")
+	mainModel, _ := claudeModels(cfg.ModelAliases, f.defaultModel(cfg))
+	fmt.Printf("    claude --model \"%s\"\n", mainModel)
+	fmt.Printf("   (claude-copilot setup-claude で Claude Code の settings.json に書き込めます)\n")
+
+	server := &http.Server{Handler: api.WithRequestID(mux)}
+	serveErr := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() { serveErr <- server.Serve(listener) }()
+	}
+
+	exitCode := exitOK
+	select {
+	case err := <-serveErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("サーバーが異常終了しました", "error", err)
+			exitCode = exitFailure
+		}
+	case <-shutdownCtx.Done():
+		// A second signal terminates immediately
+		stopSignals()
+	}
+	shutdown(server, handler, *f.shutdownGrace)
+	// The deferred calls destroy the sessions, stop the CLI and remove temp files
+	return exitCode
+}
+
+// traceFlushTimeout is how long the spans still queued get to be exported on exit
+const traceFlushTimeout = 5 * time.Second
+
+// abortTimeout is how long aborted requests get to send their error and finish
+const abortTimeout = 5 * time.Second
+
+// shutdown stops accepting connections (closing the listeners, which removes Unix
+// sockets), waits up to grace for requests in flight and then aborts the rest
+func shutdown(server *http.Server, handler *api.Handler, grace time.Duration) {
+	slog.Info("シャットダウンしています（もう一度 Ctrl-C で強制終了）", "grace", grace.String())
+	handler.Drain()
+
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	if err := server.Shutdown(ctx); err == nil {
+		slog.Info("停止しました")
+		return
+	}
+
+	slog.Warn("猶予時間内に完了しなかったリクエストを中断します")
+	handler.Abort()
+	ctx, cancel = context.WithTimeout(context.Background(), abortTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		server.Close()
+	}
+	slog.Info("停止しました")
+}
+
+// serveLoginPage serves /login on the endpoints while authentication runs, so users
+// running the proxy in the background can authenticate from the browser. It returns
+// a function that shuts the temporary server down (freeing them for the proxy).
+func serveLoginPage(endpoints []endpoint, tlsConfig *tls.Config) func() {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", api.HandleLogin)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		http.Error(w, "Copilot Proxy is authenticating", http.StatusServiceUnavailable)
+	})
+
+	listeners, err := newListeners(endpoints, tlsConfig)
+	if err != nil {
+		// The proxy itself will report the port problem later
+		return func() {}
+	}
+	if addrs := tcpAddrs(endpoints); len(addrs) > 0 {
+		auth.LoginPageURL = baseURL(addrs[0], tlsConfig != nil) + "/login"
+	}
+
+	server := &http.Server{Handler: mux}
+	for _, listener := range listeners {
+		go server.Serve(listener)
+	}
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		fmt.Printf("Server failed: %v\n", err)
-		os.Exit(1)
+	return func() {
+		auth.LoginPageURL = ""
+		server.Shutdown(context.Background())
 	}
 }
 
@@ -0,0 +1,257 @@
+// Package metrics implements the proxy's counters, gauges and histograms and
+// serves them in the Prometheus text exposition format. Metrics register
+// themselves with a single process-wide registry when created.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// OtherValue replaces label values past the limit of a Bounded label
+const OtherValue = "other"
+
+// collector is a metric family that can write itself out
+type collector interface {
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []collector
+)
+
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, c)
+}
+
+// Handler serves every registered metric (GET /metrics)
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		registryMu.Lock()
+		collectors := slices.Clone(registry)
+		registryMu.Unlock()
+		for _, c := range collectors {
+			c.write(w)
+		}
+	})
+}
+
+// vec holds the series of a metric family, keyed by their label values
+type vec[T any] struct {
+	name, help, kind string
+	labels           []string
+
+	mu     sync.Mutex
+	series map[string]*T
+	values map[string][]string // series key -> label values
+}
+
+func newVec[T any](name, help, kind string, labels []string) *vec[T] {
+	return &vec[T]{name: name, help: help, kind: kind, labels: labels, series: map[string]*T{}, values: map[string][]string{}}
+}
+
+// get returns the series for labelValues, creating it with init. v.mu must be held.
+func (v *vec[T]) getLocked(labelValues []string, init func() *T) *T {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = init()
+		v.series[key] = s
+		v.values[key] = slices.Clone(labelValues)
+	}
+	return s
+}
+
+// sortedKeysLocked returns the series keys in label order. v.mu must be held.
+func (v *vec[T]) sortedKeysLocked() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec[T]) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+// labelString formats label pairs, with extra pairs (such as le) appended
+func (v *vec[T]) labelString(values []string, extra ...string) string {
+	if len(v.labels) == 0 && len(extra) == 0 {
+		return ""
+	}
+	pairs := make([]string, 0, len(v.labels)+len(extra)/2)
+	for i, label := range v.labels {
+		pairs = append(pairs, label+`="`+escapeLabel(values[i])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	v *vec[float64]
+}
+
+// NewCounterVec creates and registers a counter family
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{v: newVec[float64](name, help, "counter", labels)}
+	register(c)
+	return c
+}
+
+// Inc adds one to the series with labelValues
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta (which must not be negative) to the series with labelValues
+func (c *CounterVec) Add(delta float64, labelValues ...string) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	*c.v.getLocked(labelValues, func() *float64 { return new(float64) }) += delta
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	c.v.writeHeader(w)
+	if len(c.v.labels) == 0 && len(c.v.series) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.v.name) // a plain counter is always exposed
+	}
+	for _, key := range c.v.sortedKeysLocked() {
+		fmt.Fprintf(w, "%s%s %s\n", c.v.name, c.v.labelString(c.v.values[key]), formatFloat(*c.v.series[key]))
+	}
+}
+
+// histogram is one series of a HistogramVec
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	v       *vec[histogram]
+	buckets []float64 // upper bounds, ascending, without +Inf
+}
+
+// NewHistogramVec creates and registers a histogram family
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{v: newVec[histogram](name, help, "histogram", labels), buckets: slices.Sorted(slices.Values(buckets))}
+	register(h)
+	return h
+}
+
+// Observe records value in the series with labelValues
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	s := h.v.getLocked(labelValues, func() *histogram {
+		return &histogram{counts: make([]uint64, len(h.buckets)+1)}
+	})
+	i, _ := slices.BinarySearch(h.buckets, value)
+	s.counts[i]++
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+	h.v.writeHeader(w)
+	for _, key := range h.v.sortedKeysLocked() {
+		s, values := h.v.series[key], h.v.values[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, h.v.labelString(values, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.v.name, h.v.labelString(values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.v.name, h.v.labelString(values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.v.name, h.v.labelString(values), s.count)
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are collected
+type GaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// NewGaugeFunc creates and registers a gauge reporting fn()
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatFloat(g.fn()))
+}
+
+// Bounded keeps a label's cardinality in check: the first n distinct values are
+// used as is and any later one is reported as OtherValue
+type Bounded struct {
+	n    int
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+// NewBounded creates a Bounded admitting n distinct values
+func NewBounded(n int) *Bounded {
+	return &Bounded{n: n, seen: map[string]bool{}}
+}
+
+// Value returns value if it is (or can become) one of the admitted values
+func (b *Bounded) Value(value string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.seen[value] {
+		return value
+	}
+	if len(b.seen) >= b.n {
+		return OtherValue
+	}
+	b.seen[value] = true
+	return value
+}
+
+// DefBuckets suit request latencies in seconds, from 100ms to 5 minutes
+var DefBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
@@ -9,6 +9,7 @@ type AnthropicRequest struct {
 	MaxTokens   int            `json:"max_tokens"`
 	Temperature *float64       `json:"temperature,omitempty"`
 	Stream      bool           `json:"stream"`
+	Tools       []interface{}  `json:"tools,omitempty"`
 }
 
 type AnthropicMsg struct {
@@ -29,6 +30,7 @@ type AnthropicMessage struct {
 	ID    string         `json:"id"`
 	Type  string         `json:"type"`
 	Role  string         `json:"role"`
+	Model string         `json:"model,omitempty"`
 	Usage AnthropicUsage `json:"usage"`
 }
 
@@ -93,3 +95,14 @@ type CopilotDelta struct {
 	Role    string `json:"role,omitempty"`
 	Content string `json:"content,omitempty"`
 }
+
+// Anthropic error response
+type AnthropicError struct {
+	Type  string               `json:"type"` // always "error"
+	Error AnthropicErrorDetail `json:"error"`
+}
+
+type AnthropicErrorDetail struct {
+	Type    string `json:"type"` // e.g. "authentication_error", "invalid_request_error"
+	Message string `json:"message"`
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"claude-copilot/api"
+	"claude-copilot/config"
+	"claude-copilot/logging"
+	"claude-copilot/redact"
+	"claude-copilot/usage"
+)
+
+// serveFlags are the proxy's command-line options. Each one can also be given
+// as an environment variable (CLAUDE_COPILOT_ + upper snake_case name) or as a
+// config file key (snake_case name), in that order of precedence after the flag.
+type serveFlags struct {
+	fs *flag.FlagSet
+
+	port              *int
+	listen            listenFlag
+	tlsCert           *string
+	tlsKey            *string
+	tlsSelfSigned     *bool
+	logoff            *bool
+	debug             *bool
+	insecure          *bool
+	caCert            *string
+	copilotCLIPath    *string
+	nodeOptions       *string
+	nodePath          *string
+	nodeBin           *string
+	cliInstallVerbose *bool
+	sdkDebug          *bool
+	cliStderr         *string
+	profile           *string
+	profileHeader     *bool
+	model             *string
+	githubHost        *string
+	githubAPIURL      *string
+	credentialStore   *string
+	openBrowser       *bool
+	showQR            *bool
+	tokenFile         *string
+	noDeviceFlow      *bool
+	requireAPIKey     *bool
+	cacheEnabled      *bool
+	cacheTTL          *time.Duration
+	cacheMaxEntries   *int
+	cacheMaxBytes     *int64
+	cacheDir          *string
+	maxConcurrent     *int
+	maxQueued         *int
+	upstreamRetries   *int
+	shutdownGrace     *time.Duration
+	logLevel          *string
+	logFormat         *string
+	auditLog          *string
+	auditPrompts      *bool
+	auditRedact       *string
+	auditMaxSize      *int64
+	auditMaxAge       *time.Duration
+	auditMaxBackups   *int
+	traceEndpoint     *string
+	traceFile         *string
+	usage             *bool
+	budgetDailySoft   *float64
+	budgetDailyHard   *float64
+	budgetMonthlySoft *float64
+	budgetMonthlyHard *float64
+
+	// sources records where non-default values came from ("flag", "env NAME", "file")
+	sources map[string]string
+}
+
+// transientFlags are actions, not settings: never read from the environment or the file
+var transientFlags = map[string]bool{
+	"logoff": true, "force": true, "json": true,
+	// env / setup-claude
+	"shell": true, "claude-config-dir": true, "main-model": true, "small-model": true, "api-key": true,
+	"settings": true, "alias": true, "alias-name": true, "rc": true, "key-name": true,
+	// audit tail
+	"n": true, "follow": true,
+}
+
+// configOwnedFlags are merged with the config file by the config package (or main),
+// because profiles can override them; only the flag and environment are applied here
+var configOwnedFlags = map[string]bool{
+	"port": true, "listen": true, "model": true, "profile": true, "require-api-key": true,
+	"github-host": true, "github-api-url": true, "credential-store": true,
+}
+
+// legacyEnv are environment variables accepted before the CLAUDE_COPILOT_ prefix existed
+var legacyEnv = map[string]string{"copilot-cli": "COPILOT_CLI_PATH"}
+
+// defineServeFlags registers the proxy options on fs
+func defineServeFlags(fs *flag.FlagSet) *serveFlags {
+	f := &serveFlags{fs: fs, sources: map[string]string{}}
+	f.port = fs.Int("port", 0, "ポート番号 (デフォルト: 8080、環境変数 PROXY_PORT でも指定可)")
+	fs.Var(&f.listen, "listen", "待受アドレス host:port または unix:///path/to.sock（複数指定可、デフォルト: 127.0.0.1）")
+	f.tlsCert = fs.String("tls-cert", "", "HTTPS で待ち受けるためのサーバー証明書ファイル（--tls-key と併用）")
+	f.tlsKey = fs.String("tls-key", "", "サーバー証明書の秘密鍵ファイル")
+	f.tlsSelfSigned = fs.Bool("tls-self-signed", false, "自己署名証明書を自動生成して HTTPS で待ち受ける")
+	f.logoff = fs.Bool("logoff", false, "認証情報を削除してログアウト（claude-copilot logout と同じ）")
+	f.debug = fs.Bool("debug", false, "詳細なデバッグログ（プロンプトの中身など）を出力する")
+	f.insecure = fs.Bool("insecure", false, "プロキシ環境などで TLS 証明書検証をスキップする（NODE_TLS_REJECT_UNAUTHORIZED=0）")
+	f.caCert = fs.String("ca-cert", "", "追加のCA証明書ファイルパス（NODE_EXTRA_CA_CERTS に設定）")
+	f.copilotCLIPath = fs.String("copilot-cli", "", "Copilot CLI のパス（環境変数 COPILOT_CLI_PATH でも指定可）")
+	f.nodeOptions = fs.String("node-options", "", "Node.js の追加オプション（NODE_OPTIONS に設定）")
+	f.nodePath = fs.String("node-path", "", "Node.js のモジュールパス（NODE_PATH に設定）")
+	f.nodeBin = fs.String("node-bin", "", "Node.js のbinディレクトリをPATHの先頭に追加")
+	f.cliInstallVerbose = fs.Bool("cli-install-verbose", false, "埋め込みCLIのインストールログを詳細化（COPILOT_CLI_INSTALL_VERBOSE=1）")
+	f.sdkDebug = fs.Bool("sdk-debug", false, "Copilot SDK のログレベルを debug に設定")
+	f.cliStderr = fs.String("cli-stderr", "", "Copilot CLI のstderrを保存するファイルパス")
+	f.profile = fs.String("profile", "", "使用するプロファイル名（claude-copilot profile add で作成）")
+	f.profileHeader = fs.Bool("profile-header", false, "リクエストヘッダー X-Copilot-Profile によるプロファイル切り替えを許可")
+	f.model = fs.String("model", "", "リクエストでモデルが省略された場合のデフォルトモデル")
+	f.githubHost = fs.String("github-host", "", "GitHub ホスト（例: acme.ghe.com、GitHub Enterprise Server のホスト名）")
+	f.githubAPIURL = fs.String("github-api-url", "", "GitHub API のベースURL（通常はホストから自動決定）")
+	f.credentialStore = fs.String("credential-store", "", "トークンの保存先: auto / keyring / file / plaintext（設定ファイルに保存される）")
+	f.openBrowser = fs.Bool("open-browser", false, "デバイス認証時に認証URLをブラウザで自動的に開く")
+	f.showQR = fs.Bool("qr", true, "デバイス認証時に認証URLをターミナルにQRコードで表示")
+	f.tokenFile = fs.String("token-file", "", "GitHub トークンを読み込むファイルパス（最優先）")
+	f.noDeviceFlow = fs.Bool("no-device-flow", false, "トークンが見つからない場合にデバイス認証を行わず即座に終了する（CI 向け）")
+	f.requireAPIKey = fs.Bool("require-api-key", false, "受信リクエストに APIキー（claude-copilot keys create で発行）を要求する")
+	f.cacheEnabled = fs.Bool("cache", false, "同一リクエストに対するレスポンスキャッシュを有効化")
+	f.cacheTTL = fs.Duration("cache-ttl", 10*time.Minute, "キャッシュエントリの有効期間")
+	f.cacheMaxEntries = fs.Int("cache-max-entries", 256, "キャッシュに保持する最大エントリ数（0 = 無制限）")
+	f.cacheMaxBytes = fs.Int64("cache-max-bytes", 64<<20, "キャッシュに保持する最大バイト数（0 = 無制限）")
+	f.cacheDir = fs.String("cache-dir", "", "キャッシュをディスクにも保存するディレクトリ（省略時はメモリのみ）")
+	f.maxConcurrent = fs.Int("max-concurrent-requests", 0, "同時に処理するリクエストの上限（0 = 無制限）")
+	f.maxQueued = fs.Int("max-queued-requests", 0, "上限到達時に待機させるリクエスト数の上限（0 = 無制限）")
+	f.upstreamRetries = fs.Int("upstream-retries", 2, "Copilot のレート制限・過負荷エラーを最初のトークン前に再試行する回数（0 = しない）")
+	f.logLevel = fs.String("log-level", "info", "ログレベル: debug / info / warn / error（-debug 指定時は debug）")
+	f.logFormat = fs.String("log-format", "text", "ログの形式: text / json（標準エラー出力に書き出す）")
+	f.auditLog = fs.String("audit-log", "", "リクエストごとの監査ログ（JSONL）の出力先（省略時は無効）")
+	f.auditPrompts = fs.Bool("audit-prompts", false, "監査ログにプロンプトと応答の本文も記録する（マスク処理後）")
+	f.auditRedact = fs.String("audit-redact", "secrets,emails,paths", "監査ログでマスクする情報（secrets / emails / paths のカンマ区切り、空で無効）")
+	f.auditMaxSize = fs.Int64("audit-max-size", 100<<20, "監査ログをローテートするサイズ（バイト、0 = しない）")
+	f.auditMaxAge = fs.Duration("audit-max-age", 24*time.Hour, "監査ログをローテートする経過時間（0 = しない）")
+	f.auditMaxBackups = fs.Int("audit-max-backups", 30, "保持するローテート済み監査ログの数（0 = すべて）")
+	f.traceEndpoint = fs.String("trace-endpoint", "", "トレースを送信する OTLP/HTTP コレクターの URL（例: http://localhost:4318）")
+	f.traceFile = fs.String("trace-file", "", "コレクターを使わない場合にトレースを書き出すファイル（OTLP/JSON）")
+	f.usage = fs.Bool("usage", true, "APIキー・モデルごとの利用量（リクエスト数・プレミアムリクエスト・トークン）を記録する")
+	f.budgetDailySoft = fs.Float64("budget-daily-soft", 0, "APIキーごとの1日のプレミアムリクエスト数の警告値（0 = なし）")
+	f.budgetDailyHard = fs.Float64("budget-daily-hard", 0, "APIキーごとの1日のプレミアムリクエスト数の上限（超えると rate_limit_error、0 = なし）")
+	f.budgetMonthlySoft = fs.Float64("budget-monthly-soft", 0, "APIキーごとの1か月のプレミアムリクエスト数の警告値（0 = なし）")
+	f.budgetMonthlyHard = fs.Float64("budget-monthly-hard", 0, "APIキーごとの1か月のプレミアムリクエスト数の上限（超えると rate_limit_error、0 = なし）")
+	f.shutdownGrace = fs.Duration("shutdown-grace", 30*time.Second, "終了時に処理中のリクエストの完了を待つ時間（超えたものはエラーで中断）")
+	return f
+}
+
+// optionKey returns the config file key of a flag
+func optionKey(name string) string {
+	return strings.ReplaceAll(name, "-", "_")
+}
+
+// optionEnv returns the environment variable of a flag
+func optionEnv(name string) string {
+	return "CLAUDE_COPILOT_" + strings.ToUpper(optionKey(name))
+}
+
+// applyEnv fills flags not given on the command line from the environment.
+// It runs before the config file is loaded, so it may select the host or store.
+func (f *serveFlags) applyEnv() error {
+	explicit := map[string]bool{}
+	f.fs.Visit(func(fl *flag.Flag) {
+		explicit[fl.Name] = true
+		f.sources[fl.Name] = "flag"
+	})
+
+	var errs []error
+	f.fs.VisitAll(func(fl *flag.Flag) {
+		if explicit[fl.Name] || transientFlags[fl.Name] {
+			return
+		}
+		for _, env := range []string{optionEnv(fl.Name), legacyEnv[fl.Name]} {
+			value, ok := os.LookupEnv(env)
+			if env == "" || !ok || value == "" {
+				continue
+			}
+			if err := f.fs.Set(fl.Name, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q: %v", env, value, err))
+			}
+			f.sources[fl.Name] = "env " + env
+			return
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// applyConfig fills the remaining flags from the config file
+func (f *serveFlags) applyConfig(cfg *config.AppConfig) error {
+	var errs []error
+	f.fs.VisitAll(func(fl *flag.Flag) {
+		if _, set := f.sources[fl.Name]; set || transientFlags[fl.Name] || configOwnedFlags[fl.Name] {
+			return
+		}
+		value, ok := cfg.FileValue(optionKey(fl.Name))
+		if !ok {
+			return
+		}
+		if err := f.fs.Set(fl.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q in %s: %v", optionKey(fl.Name), value, config.GetConfigPath(), err))
+		}
+		f.sources[fl.Name] = "file"
+	})
+	return errors.Join(errs...)
+}
+
+// validate checks the resolved options; file paths must exist
+func (f *serveFlags) validate(cfg *config.AppConfig) error {
+	var errs []error
+	check := func(name, path string, dir bool) {
+		if path == "" {
+			return
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s (%s): %v", optionKey(name), f.source(cfg, name), err))
+		case dir && !info.IsDir():
+			errs = append(errs, fmt.Errorf("%s (%s): %s is not a directory", optionKey(name), f.source(cfg, name), path))
+		case !dir && info.IsDir():
+			errs = append(errs, fmt.Errorf("%s (%s): %s is a directory", optionKey(name), f.source(cfg, name), path))
+		}
+	}
+	check("ca-cert", *f.caCert, false)
+	check("copilot-cli", *f.copilotCLIPath, false)
+	check("node-bin", *f.nodeBin, true)
+	check("token-file", *f.tokenFile, false)
+	check("tls-cert", *f.tlsCert, false)
+	check("tls-key", *f.tlsKey, false)
+	if *f.cliStderr != "" {
+		check("cli-stderr", filepath.Dir(*f.cliStderr), true)
+	}
+	if _, err := redact.ParseCategories(*f.auditRedact); err != nil {
+		errs = append(errs, fmt.Errorf("audit_redact (%s): %v", f.source(cfg, "audit-redact"), err))
+	}
+	if err := new(redact.Redactor).AddPatterns(cfg.RedactPatterns...); err != nil {
+		errs = append(errs, fmt.Errorf("redact_patterns (%s): %v", cfg.Source("redact_patterns"), err))
+	}
+	if *f.auditMaxSize < 0 || *f.auditMaxAge < 0 || *f.auditMaxBackups < 0 {
+		errs = append(errs, errors.New("audit_max_size, audit_max_age and audit_max_backups must not be negative"))
+	}
+	if *f.maxConcurrent < 0 || *f.maxQueued < 0 {
+		errs = append(errs, errors.New("max_concurrent_requests and max_queued_requests must not be negative"))
+	}
+	if *f.upstreamRetries < 0 {
+		errs = append(errs, errors.New("upstream_retries must not be negative"))
+	}
+	if *f.traceEndpoint != "" {
+		if u, err := url.Parse(*f.traceEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("trace_endpoint (%s): %q is not an http(s) URL", f.source(cfg, "trace-endpoint"), *f.traceEndpoint))
+		}
+	}
+	if *f.budgetDailySoft < 0 || *f.budgetDailyHard < 0 || *f.budgetMonthlySoft < 0 || *f.budgetMonthlyHard < 0 {
+		errs = append(errs, errors.New("budget_daily_soft, budget_daily_hard, budget_monthly_soft and budget_monthly_hard must not be negative"))
+	}
+	for name, b := range cfg.KeyBudgets {
+		if b == nil || b.DailySoft < 0 || b.DailyHard < 0 || b.MonthlySoft < 0 || b.MonthlyHard < 0 {
+			errs = append(errs, fmt.Errorf("key_budgets (%s): the budget of %q must not be negative", cfg.Source("key_budgets"), name))
+		}
+	}
+	if !*f.usage && (!f.budgets(cfg).Default.IsZero() || len(cfg.KeyBudgets) > 0) {
+		errs = append(errs, errors.New("budgets need usage accounting (usage is off)"))
+	}
+	if *f.shutdownGrace < 0 {
+		errs = append(errs, errors.New("shutdown_grace must not be negative"))
+	}
+	if _, err := logging.ParseLevel(*f.logLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log_level (%s): %v", f.source(cfg, "log-level"), err))
+	}
+	if !slices.Contains(logging.Formats, *f.logFormat) {
+		errs = append(errs, fmt.Errorf("log_format (%s): unknown format %q (text or json)", f.source(cfg, "log-format"), *f.logFormat))
+	}
+	return errors.Join(errs...)
+}
+
+// source describes where the effective value of a flag came from
+func (f *serveFlags) source(cfg *config.AppConfig, name string) string {
+	if source, ok := f.sources[name]; ok {
+		return source
+	}
+	if configOwnedFlags[name] {
+		return cfg.Source(optionKey(name))
+	}
+	return "default"
+}
+
+// portString returns the port: flag > env > profile > config file > 8080
+func (f *serveFlags) portString(cfg *config.AppConfig) string {
+	if *f.port != 0 {
+		return strconv.Itoa(*f.port)
+	}
+	if cfg.Port != "" {
+		return cfg.Port
+	}
+	return "8080"
+}
+
+// listenValues returns the --listen values, falling back to the config file
+func (f *serveFlags) listenValues(cfg *config.AppConfig) []string {
+	if len(f.listen) == 0 && cfg.Listen != "" {
+		var values listenFlag
+		values.Set(cfg.Listen)
+		return values
+	}
+	return f.listen
+}
+
+// defaultModel returns the model used when a request omits one
+func (f *serveFlags) defaultModel(cfg *config.AppConfig) string {
+	if *f.model != "" {
+		return *f.model
+	}
+	return cfg.Model
+}
+
+// minLogLevel returns the minimum level logged; -debug lowers it to debug
+func (f *serveFlags) minLogLevel() slog.Level {
+	if *f.debug {
+		return slog.LevelDebug
+	}
+	level, _ := logging.ParseLevel(*f.logLevel) // checked by validate
+	return level
+}
+
+// redactor returns a Redactor for the comma-separated categories plus the
+// redact_patterns of the config file
+func (f *serveFlags) redactor(cfg *config.AppConfig, categories string) *redact.Redactor {
+	r, _ := redact.ParseCategories(categories) // checked by validate
+	r.AddPatterns(cfg.RedactPatterns...)
+	return r
+}
+
+// budgets returns the usage budgets: the budget_* options for every key, with
+// the key_budgets of the config file replacing them for the keys listed
+func (f *serveFlags) budgets(cfg *config.AppConfig) *usage.Budgets {
+	b := &usage.Budgets{
+		Default: usage.Budget{
+			DailySoft:   *f.budgetDailySoft,
+			DailyHard:   *f.budgetDailyHard,
+			MonthlySoft: *f.budgetMonthlySoft,
+			MonthlyHard: *f.budgetMonthlyHard,
+		},
+		Keys: map[string]usage.Budget{},
+	}
+	for name, kb := range cfg.KeyBudgets {
+		if kb != nil {
+			b.Keys[name] = usage.Budget(*kb)
+		}
+	}
+	return b
+}
+
+// apiKeyRequired reports whether inbound requests must carry an API key: the
+// flag or environment when given (even when false), otherwise the config file
+func (f *serveFlags) apiKeyRequired(cfg *config.AppConfig) bool {
+	if _, set := f.sources["require-api-key"]; set {
+		return *f.requireAPIKey
+	}
+	return cfg.RequireAPIKey
+}
+
+// handlerSettings returns the API handler settings (all of them reloadable)
+func (f *serveFlags) handlerSettings(cfg *config.AppConfig) *api.Settings {
+	return &api.Settings{
+		Debug:         *f.debug,
+		DefaultModel:  f.defaultModel(cfg),
+		ModelAliases:  cfg.ModelAliases,
+		RequireAPIKey: f.apiKeyRequired(cfg),
+		Budgets:       f.budgets(cfg),
+		Retries:       *f.upstreamRetries,
+	}
+}
+
+// loadConfig loads the config file and resolves f against the environment and
+// the file: flag > environment > (profile >) config file > default
+func (f *serveFlags) loadConfig() (*config.AppConfig, error) {
+	if err := f.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	config.CredentialStoreOverride = *f.credentialStore
+	config.GitHubHostOverride = *f.githubHost
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if *f.profile != "" {
+		if err := cfg.SelectProfile(*f.profile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.applyConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, f.validate(cfg)
+}
+
+// reloadConfig resolves f like loadConfig, but from config.ReadConfig: the
+// token, the credential store and the process-wide overrides set on startup
+// are left alone, since requests are being served
+func (f *serveFlags) reloadConfig() (*config.AppConfig, error) {
+	if err := f.applyEnv(); err != nil {
+		return nil, err
+	}
+	cfg, err := config.ReadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if *f.profile != "" {
+		if err := cfg.SelectProfileSettings(*f.profile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := f.applyConfig(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, f.validate(cfg)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	copilot "github.com/github/copilot-sdk/go"
+
+	"claude-copilot/auth"
+	"claude-copilot/config"
+)
+
+// runProfileCommand implements `claude-copilot profile add|list|remove`
+func runProfileCommand(args []string) int {
+	usage := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  claude-copilot profile add NAME [-model MODEL] [-port PORT] [-github-host HOST]")
+		fmt.Println("  claude-copilot profile list")
+		fmt.Println("  claude-copilot profile remove NAME")
+	}
+	if len(args) == 0 {
+		usage()
+		return exitUsage
+	}
+	if isHelpArg(args[0]) {
+		usage()
+		return exitOK
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 設定の読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+
+	switch args[0] {
+	case "add":
+		fs := flag.NewFlagSet("profile add", flag.ContinueOnError)
+		model := fs.String("model", "", "このプロファイルのデフォルトモデル")
+		port := fs.String("port", "", "このプロファイルの待受ポート")
+		githubHost := fs.String("github-host", "", "このプロファイルの GitHub ホスト（例: acme.ghe.com）")
+		githubAPIURL := fs.String("github-api-url", "", "このプロファイルの GitHub API ベースURL")
+		const addUsage = "profile add NAME [FLAGS]"
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			// NAME is missing; still answer -h
+			if code, ok := parseCommandFlags(fs, addUsage, args[1:]); !ok {
+				return code
+			}
+			fs.Usage()
+			return exitUsage
+		}
+		name := args[1]
+		if code, ok := parseCommandFlags(fs, addUsage, args[2:]); !ok {
+			return code
+		}
+
+		if _, exists := cfg.Profiles[name]; exists {
+			fmt.Fprintf(os.Stderr, "❌ プロファイル %q は既に存在します\n", name)
+			return exitFailure
+		}
+		if cfg.Profiles == nil {
+			cfg.Profiles = map[string]*config.Profile{}
+		}
+		cfg.Profiles[name] = &config.Profile{
+			Model:        *model,
+			Port:         *port,
+			GitHubHost:   *githubHost,
+			GitHubAPIURL: *githubAPIURL,
+		}
+		if err := config.SaveConfig(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 設定の保存に失敗しました: %v\n", err)
+			return exitFailure
+		}
+
+		// Authenticate the new account right away; a profile that never logged in
+		// is removed again so the command can simply be retried
+		if err := cfg.SelectProfile(name); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			removeFailedProfile(name)
+			return exitFailure
+		}
+		auth.GitHubHost = cfg.Host()
+		auth.APIBaseURL = cfg.GitHubAPIURL
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		// Always sign in: a token from the environment or the gh CLI may be another account
+		if err := auth.Login(ctx, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 認証に失敗しました: %v\n", err)
+			removeFailedProfile(name)
+			return exitFailure
+		}
+		fmt.Printf("✅ プロファイル %q を追加しました\n", name)
+		return exitOK
+
+	case "list":
+		if len(cfg.Profiles) == 0 {
+			fmt.Println("プロファイルはありません（claude-copilot profile add NAME で追加）")
+			return exitOK
+		}
+		names := make([]string, 0, len(cfg.Profiles))
+		for name := range cfg.Profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Printf("%-16s %-20s %-6s %-20s %s\n", "NAME", "MODEL", "PORT", "HOST", "TOKEN")
+		for _, name := range names {
+			p := cfg.Profiles[name]
+			token, err := cfg.ProfileToken(name)
+			state := "stored"
+			switch {
+			case err != nil:
+				state = "error: " + err.Error()
+			case token == "":
+				state = "-"
+			}
+			fmt.Printf("%-16s %-20s %-6s %-20s %s\n", name, valueOrDash(p.Model), valueOrDash(p.Port), valueOrDash(p.GitHubHost), state)
+		}
+		return exitOK
+
+	case "remove":
+		if len(args) < 2 {
+			usage()
+			return exitUsage
+		}
+		if err := cfg.RemoveProfile(args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		fmt.Printf("✅ プロファイル %q を削除しました\n", args[1])
+		return exitOK
+
+	default:
+		usage()
+		return exitUsage
+	}
+}
+
+// removeFailedProfile removes a profile added by `profile add` whose login failed
+func removeFailedProfile(name string) {
+	cfg, err := config.LoadConfig()
+	if err == nil {
+		err = cfg.RemoveProfile(name)
+	}
+	if err != nil {
+		fmt.Printf("⚠️  プロファイル %q を削除できませんでした（claude-copilot profile remove %s で削除してください）: %v\n", name, name, err)
+	}
+}
+
+func valueOrDash(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// profileClients lazily starts one Copilot client per profile, for requests that
+// pick an account with the profile header
+type profileClients struct {
+	cfg  *config.AppConfig
+	opts copilot.ClientOptions
+
+	mu      sync.Mutex
+	clients map[string]*profileStart
+	stopped bool
+}
+
+// profileStart is the start of one profile's client; requests for the profile
+// wait on done while another one starts it
+type profileStart struct {
+	done   chan struct{}
+	client *copilot.Client
+	err    error
+}
+
+func newProfileClients(cfg *config.AppConfig, opts copilot.ClientOptions) *profileClients {
+	return &profileClients{cfg: cfg, opts: opts, clients: map[string]*profileStart{}}
+}
+
+// Get returns the started client for the named profile. The first request for
+// a profile starts its client without holding the lock, so other profiles are
+// served meanwhile; a failed start is retried by the next request.
+func (p *profileClients) Get(name string) (*copilot.Client, error) {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("profile clients are stopped")
+	}
+	s, ok := p.clients[name]
+	if !ok {
+		s = &profileStart{done: make(chan struct{})}
+		p.clients[name] = s
+	}
+	p.mu.Unlock()
+
+	if ok {
+		<-s.done
+		return s.client, s.err
+	}
+
+	s.client, s.err = p.start(name)
+	p.mu.Lock()
+	switch {
+	case s.err != nil:
+		delete(p.clients, name)
+	case p.stopped:
+		// StopAll ran while the client was starting
+		s.client.Stop()
+		s.client, s.err = nil, fmt.Errorf("profile clients are stopped")
+	}
+	close(s.done) // under the lock, so StopAll sees either a running start or its client
+	p.mu.Unlock()
+	return s.client, s.err
+}
+
+// start starts the client of a profile
+func (p *profileClients) start(name string) (*copilot.Client, error) {
+	profile := *p.cfg
+	if err := profile.SelectProfile(name); err != nil {
+		return nil, err
+	}
+	if profile.GitHubToken == "" {
+		return nil, fmt.Errorf("profile %q is not logged in (run: %s profile add %s)", name, os.Args[0], name)
+	}
+
+	opts := p.opts
+	opts.GitHubToken = profile.GitHubToken
+	opts.Env = setEnvValue(slices.Clone(p.opts.Env), "GH_HOST", profile.Host())
+	client := copilot.NewClient(&opts)
+	// Not the request context: the client outlives the request that started it
+	if err := client.Start(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to start Copilot CLI for profile %q: %w", name, err)
+	}
+	slog.Info("プロファイルの Copilot クライアントを起動しました", "profile", name)
+	return client, nil
+}
+
+// Started returns the clients started so far by profile name (none for a nil
+// *profileClients)
+func (p *profileClients) Started() map[string]*copilot.Client {
+	clients := map[string]*copilot.Client{}
+	if p == nil {
+		return clients
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for name, s := range p.clients {
+		select {
+		case <-s.done:
+			if s.client != nil {
+				clients[name] = s.client
+			}
+		default:
+		}
+	}
+	return clients
+}
+
+// StopAll stops every client started by Get; clients still starting are stopped
+// as soon as they are up
+func (p *profileClients) StopAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stopped = true
+	for _, s := range p.clients {
+		select {
+		case <-s.done:
+			if s.client != nil {
+				s.client.Stop()
+			}
+		default:
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package qrcode
+
+import (
+	"errors"
+	"strings"
+)
+
+// Small QR Code encoder (byte mode, error correction level L, versions 1-5).
+// That is enough for device-flow verification URLs (up to 106 bytes) without
+// pulling in a dependency.
+
+// ErrTooLong is returned when the text does not fit in a version 5 symbol
+var ErrTooLong = errors.New("qrcode: text too long")
+
+// version parameters for error correction level L (all single-block)
+var versions = []struct {
+	dataCodewords int
+	ecCodewords   int
+	alignment     int // center of the bottom-right alignment pattern (0 = none)
+}{
+	{19, 7, 0},    // 1
+	{34, 10, 18},  // 2
+	{55, 15, 22},  // 3
+	{80, 20, 26},  // 4
+	{108, 26, 30}, // 5
+}
+
+// Code is an encoded symbol; Modules[y][x] is true for dark modules
+type Code struct {
+	Size    int
+	Modules [][]bool
+}
+
+// Encode encodes text as a QR Code
+func Encode(text string) (*Code, error) {
+	data := []byte(text)
+
+	version := 0
+	for i, v := range versions {
+		// 4-bit mode indicator + 8-bit length + payload
+		if 12+len(data)*8 <= v.dataCodewords*8 {
+			version = i + 1
+			break
+		}
+	}
+	if version == 0 {
+		return nil, ErrTooLong
+	}
+	params := versions[version-1]
+
+	codewords := encodeData(data, params.dataCodewords)
+	codewords = append(codewords, reedSolomon(codewords, params.ecCodewords)...)
+
+	q := newSymbol(version, params.alignment)
+	q.placeCodewords(codewords)
+
+	// Keep the mask with the lowest penalty
+	var best *symbol
+	bestPenalty := -1
+	for mask := 0; mask < 8; mask++ {
+		candidate := q.clone()
+		candidate.applyMask(mask)
+		candidate.drawFormatBits(mask)
+		if p := candidate.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = candidate, p
+		}
+	}
+
+	return &Code{Size: best.size, Modules: best.modules}, nil
+}
+
+// Terminal renders the code with ANSI colors and half-block characters
+// (two modules per character row), including a quiet zone
+func (c *Code) Terminal() string {
+	const quiet = 2
+	dark := func(x, y int) bool {
+		x, y = x-quiet, y-quiet
+		return x >= 0 && y >= 0 && x < c.Size && y < c.Size && c.Modules[y][x]
+	}
+
+	var b strings.Builder
+	total := c.Size + 2*quiet
+	for y := 0; y < total; y += 2 {
+		b.WriteString("\x1b[30;47m") // black on white, regardless of terminal theme
+		for x := 0; x < total; x++ {
+			top, bottom := dark(x, y), dark(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
+
+// encodeData builds the data codewords: mode, length, payload, terminator and padding
+func encodeData(data []byte, capacity int) []byte {
+	var bits []bool
+	put := func(value, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (value>>i)&1 == 1)
+		}
+	}
+
+	put(0b0100, 4) // byte mode
+	put(len(data), 8)
+	for _, b := range data {
+		put(int(b), 8)
+	}
+	put(0, min(4, capacity*8-len(bits))) // terminator
+	for len(bits)%8 != 0 {
+		bits = append(bits, false)
+	}
+
+	out := make([]byte, 0, capacity)
+	for i := 0; i < len(bits); i += 8 {
+		var b byte
+		for j := 0; j < 8; j++ {
+			if bits[i+j] {
+				b |= 1 << (7 - j)
+			}
+		}
+		out = append(out, b)
+	}
+	for pad := byte(0xEC); len(out) < capacity; pad ^= 0xEC ^ 0x11 {
+		out = append(out, pad)
+	}
+	return out
+}
+
+// GF(256) arithmetic with the QR primitive polynomial x^8+x^4+x^3+x^2+1
+var gfExp, gfLog = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// reedSolomon returns the n error correction codewords for data
+func reedSolomon(data []byte, n int) []byte {
+	// Generator polynomial (x - a^0)(x - a^1)...(x - a^(n-1)), highest degree first
+	gen := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(gen)+1)
+		for j, c := range gen {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		gen = next
+	}
+
+	msg := make([]byte, len(data)+n)
+	copy(msg, data)
+	for i := range data {
+		coef := msg[i]
+		if coef == 0 {
+			continue
+		}
+		for j := 1; j < len(gen); j++ {
+			msg[i+j] ^= gfMul(gen[j], coef)
+		}
+	}
+	return msg[len(data):]
+}
+
+// symbol is the module matrix under construction
+type symbol struct {
+	size       int
+	modules    [][]bool
+	isFunction [][]bool
+}
+
+func newSymbol(version, alignment int) *symbol {
+	size := 17 + 4*version
+	q := &symbol{size: size, modules: grid(size), isFunction: grid(size)}
+
+	// Timing patterns (finders drawn afterwards overwrite the ends)
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	// Finder patterns with separators
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x < 0 || y < 0 || x >= size || y >= size {
+					continue
+				}
+				dist := max(abs(dx), abs(dy))
+				q.setFunction(x, y, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	// Versions 2-6 have a single alignment pattern in the bottom-right
+	if alignment > 0 {
+		for dy := -2; dy <= 2; dy++ {
+			for dx := -2; dx <= 2; dx++ {
+				q.setFunction(alignment+dx, alignment+dy, max(abs(dx), abs(dy)) != 1)
+			}
+		}
+	}
+
+	// Reserve format areas (and the dark module) before placing data
+	q.drawFormatBits(0)
+	return q
+}
+
+func grid(size int) [][]bool {
+	g := make([][]bool, size)
+	for i := range g {
+		g[i] = make([]bool, size)
+	}
+	return g
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func (q *symbol) setFunction(x, y int, dark bool) {
+	q.modules[y][x] = dark
+	q.isFunction[y][x] = true
+}
+
+func (q *symbol) clone() *symbol {
+	c := &symbol{size: q.size, modules: grid(q.size), isFunction: q.isFunction}
+	for y := range q.modules {
+		copy(c.modules[y], q.modules[y])
+	}
+	return c
+}
+
+// placeCodewords fills the data area in the zigzag order defined by the spec
+func (q *symbol) placeCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5 // skip the vertical timing pattern
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				upward := (right+1)&2 == 0
+				y := vert
+				if upward {
+					y = q.size - 1 - vert
+				}
+				if !q.isFunction[y][x] && i < len(data)*8 {
+					q.modules[y][x] = (data[i>>3]>>(7-i&7))&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+func (q *symbol) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.isFunction[y][x] {
+				continue
+			}
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			q.modules[y][x] = q.modules[y][x] != invert
+		}
+	}
+}
+
+// drawFormatBits writes both copies of the format information (level L + mask)
+func (q *symbol) drawFormatBits(mask int) {
+	data := 1<<3 | mask // level L = 01
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = (rem << 1) ^ ((rem >> 9) * 0x537)
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 == 1 }
+
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, q.size-15+i, bit(i))
+	}
+	q.setFunction(8, q.size-8, true) // dark module
+}
+
+// penalty scores the symbol with the spec's mask evaluation rules
+func (q *symbol) penalty() int {
+	score := 0
+	at := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modules[x][y]
+		}
+		return q.modules[y][x]
+	}
+
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < q.size; y++ {
+			// Rule 1: runs of five or more same-colored modules
+			run := 1
+			for x := 1; x < q.size; x++ {
+				if at(x, y, vertical) == at(x-1, y, vertical) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					score += run - 2
+				}
+				run = 1
+			}
+			if run >= 5 {
+				score += run - 2
+			}
+
+			// Rule 3: finder-like 1:1:3:1:1 patterns next to four light modules
+			for x := 0; x+10 < q.size; x++ {
+				var pattern [11]bool
+				for i := range pattern {
+					pattern[i] = at(x+i, y, vertical)
+				}
+				if pattern == [11]bool{true, false, true, true, true, false, true, false, false, false, false} ||
+					pattern == [11]bool{false, false, false, false, true, false, true, true, true, false, true} {
+					score += 40
+				}
+			}
+		}
+	}
+
+	// Rule 2: 2x2 blocks of the same color
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.modules[y][x] {
+				dark++
+			}
+			if x+1 < q.size && y+1 < q.size {
+				c := q.modules[y][x]
+				if q.modules[y][x+1] == c && q.modules[y+1][x] == c && q.modules[y+1][x+1] == c {
+					score += 3
+				}
+			}
+		}
+	}
+
+	// Rule 4: balance of dark and light modules
+	total := q.size * q.size
+	deviation := abs(dark*20-total*10) / total
+	score += deviation * 10
+
+	return score
+}
@@ -0,0 +1,120 @@
+package qrcode
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// "HELLO WORLD" at version 1-M (ISO/IEC 18004 worked example)
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := reedSolomon(data, len(want)); !bytes.Equal(got, want) {
+		t.Errorf("reedSolomon = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeData(t *testing.T) {
+	// 0100 (byte mode) 00000001 (length) 01000001 ("A") 0000 (terminator), then padding
+	want := []byte{0x40, 0x14, 0x10, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	if got := encodeData([]byte("A"), 19); !bytes.Equal(got, want) {
+		t.Errorf("encodeData = % X, want % X", got, want)
+	}
+}
+
+// formatL is the format information of level L for masks 0-7 (ISO/IEC 18004 table C.1)
+var formatL = []string{
+	"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+	"110011000101111", "110001100011000", "110110001000001", "110100101110110",
+}
+
+func TestFormatBits(t *testing.T) {
+	for mask, want := range formatL {
+		q := newSymbol(1, 0)
+		q.drawFormatBits(mask)
+		top, bottom := readFormat(q.modules, q.size)
+		if top != want || bottom != want {
+			t.Errorf("mask %d: format bits %s / %s, want %s", mask, top, bottom, want)
+		}
+	}
+}
+
+// readFormat reads both copies of the format information, most significant bit first
+func readFormat(m [][]bool, size int) (top, bottom string) {
+	var a, b [15]bool
+	for i := 0; i <= 5; i++ {
+		a[i] = m[i][8]
+	}
+	a[6], a[7], a[8] = m[7][8], m[8][8], m[8][7]
+	for i := 9; i < 15; i++ {
+		a[i] = m[8][14-i]
+	}
+	for i := 0; i < 8; i++ {
+		b[i] = m[8][size-1-i]
+	}
+	for i := 8; i < 15; i++ {
+		b[i] = m[size-15+i][8]
+	}
+	str := func(bits [15]bool) string {
+		var s strings.Builder
+		for i := 14; i >= 0; i-- {
+			if bits[i] {
+				s.WriteByte('1')
+			} else {
+				s.WriteByte('0')
+			}
+		}
+		return s.String()
+	}
+	return str(a), str(b)
+}
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+	}{
+		{"a", 21},
+		{"https://github.com/login/device", 25},
+		{strings.Repeat("x", 106), 37},
+	}
+	for _, tt := range tests {
+		code, err := Encode(tt.text)
+		if err != nil {
+			t.Fatalf("Encode(%d bytes): %v", len(tt.text), err)
+		}
+		if code.Size != tt.size || len(code.Modules) != tt.size {
+			t.Errorf("Encode(%d bytes): size %d, want %d", len(tt.text), code.Size, tt.size)
+		}
+		m := code.Modules
+
+		// Finder patterns: dark outer ring, light ring, dark 3x3 center
+		for _, c := range [][2]int{{3, 3}, {tt.size - 4, 3}, {3, tt.size - 4}} {
+			for _, d := range [][3]int{{-3, 0, 1}, {-2, 0, 0}, {0, 0, 1}, {1, 1, 1}} {
+				if got := m[c[1]+d[1]][c[0]+d[0]]; got != (d[2] == 1) {
+					t.Errorf("Encode(%d bytes): finder at %v is wrong", len(tt.text), c)
+				}
+			}
+		}
+		// Timing patterns alternate between the finders
+		for i := 8; i < tt.size-8; i++ {
+			if m[6][i] != (i%2 == 0) || m[i][6] != (i%2 == 0) {
+				t.Errorf("Encode(%d bytes): timing pattern broken at %d", len(tt.text), i)
+			}
+		}
+		if !m[tt.size-8][8] {
+			t.Errorf("Encode(%d bytes): dark module missing", len(tt.text))
+		}
+		top, bottom := readFormat(m, tt.size)
+		if top != bottom || !slices.Contains(formatL, top) {
+			t.Errorf("Encode(%d bytes): format bits %s / %s are not level L", len(tt.text), top, bottom)
+		}
+	}
+
+	if _, err := Encode(strings.Repeat("x", 107)); !errors.Is(err, ErrTooLong) {
+		t.Errorf("Encode(107 bytes) error = %v, want ErrTooLong", err)
+	}
+}
@@ -0,0 +1,222 @@
+// Package redact masks sensitive text (secrets, email addresses, file paths and
+// user-defined patterns) before it is written to logs.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Categories of sensitive text that can be masked
+const (
+	Secrets = "secrets"
+	Emails  = "emails"
+	Paths   = "paths"
+)
+
+// Categories lists every category, in the order rules are applied
+var Categories = []string{Secrets, Emails, Paths}
+
+// rule replaces the matches of re with replacement (which may refer to groups),
+// except those keep accepts
+type rule struct {
+	re          *regexp.Regexp
+	replacement string
+	keep        func(groups []string) bool // optional
+}
+
+func (r rule) apply(s string) string {
+	if r.keep == nil {
+		return r.re.ReplaceAllString(s, r.replacement)
+	}
+	return r.re.ReplaceAllStringFunc(s, func(match string) string {
+		if r.keep(r.re.FindStringSubmatch(match)) {
+			return match
+		}
+		return r.re.ReplaceAllString(match, r.replacement)
+	})
+}
+
+// pathPrefix keeps the character before an absolute path (so "://" in URLs never
+// starts one) and is restored by the replacement
+const pathPrefix = `(^|[\s"'(\[=,])`
+
+var rules = map[string][]rule{
+	Secrets: {
+		// PEM private keys, including ones cut off before the END line
+		{re: regexp.MustCompile(`-----BEGIN[A-Z ]*PRIVATE KEY-----(?:[\s\S]*?-----END[A-Z ]*PRIVATE KEY-----|[A-Za-z0-9+/=\s]*)`), replacement: "[REDACTED:private-key]"},
+		// JSON Web Tokens (header.payload.signature)
+		{re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]*`), replacement: "[REDACTED:jwt]"},
+		// GitHub tokens (classic and fine-grained)
+		{re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`), replacement: "[REDACTED:github-token]"},
+		// AWS access key IDs
+		{re: regexp.MustCompile(`\b(?:AKIA|ASIA|ABIA|ACCA)[A-Z0-9]{16}\b`), replacement: "[REDACTED:aws-key]"},
+		// Authorization headers; lowercase words are prose ("token authentication_required")
+		{
+			re:          regexp.MustCompile(`(?i)\b(bearer|token|basic)\s+([A-Za-z0-9._~+/=-]{16,})`),
+			replacement: "${1} [REDACTED:credential]",
+			keep:        func(groups []string) bool { return proseWord.MatchString(groups[2]) },
+		},
+		// OpenAI / Anthropic style API keys
+		{re: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}\b`), replacement: "[REDACTED:api-key]"},
+		// Random-looking values of KEY=value lines (.env files, shell exports, YAML),
+		// such as AWS secret keys and passwords
+		{
+			re:          regexp.MustCompile(`(?m)(^[ \t]*(?:export[ \t]+)?[A-Za-z_][A-Za-z0-9_.-]*[ \t]*[=:][ \t]*["']?)([^\s"'#]{16,})`),
+			replacement: "${1}[REDACTED:secret]",
+			keep:        func(groups []string) bool { return !looksRandom(groups[2]) },
+		},
+	},
+	Emails: {
+		{re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}`), replacement: "[REDACTED:email]"},
+	},
+	Paths: {
+		// Unix paths with at least two segments (/home/alice/..., /etc/ssl/...)
+		{re: regexp.MustCompile(pathPrefix + `(/(?:[\w.@+-]+/)+[\w.@+-]*)`), replacement: "${1}[REDACTED:path]"},
+		// Windows paths (C:\Users\alice\...)
+		{re: regexp.MustCompile(pathPrefix + `([A-Za-z]:\\(?:[\w.@+ -]+\\)*[\w.@+-]*)`), replacement: "${1}[REDACTED:path]"},
+		// Home-relative paths (~/projects/...)
+		{re: regexp.MustCompile(pathPrefix + `(~/(?:[\w.@+-]+/?)+)`), replacement: "${1}[REDACTED:path]"},
+	},
+}
+
+// proseWord matches identifiers made of lowercase words, which follow "token" in
+// error messages but are never credentials
+var proseWord = regexp.MustCompile(`^[a-z_-]+$`)
+
+// versionString matches version numbers such as 1.24.0-linux-amd64
+var versionString = regexp.MustCompile(`^v?\d+(?:\.\d+)+(?:[-+]|$)`)
+
+// minSecretEntropy is the Shannon entropy (bits per character) above which a
+// KEY=value value is taken for a secret; English words and paths stay below it
+const minSecretEntropy = 3.5
+
+// looksRandom reports whether value looks like a generated secret: letters and
+// digits with high entropy, and not a URL, path or version
+func looksRandom(value string) bool {
+	if strings.Contains(value, "://") || strings.ContainsAny(value[:1], "/~.") || versionString.MatchString(value) {
+		return false
+	}
+	if !strings.ContainsAny(value, "0123456789") || strings.IndexFunc(value, unicode.IsLetter) < 0 {
+		return false
+	}
+	return entropy(value) >= minSecretEntropy
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := map[rune]int{}
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	var bits float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		bits -= p * math.Log2(p)
+	}
+	return bits
+}
+
+// Redactor masks the enabled categories of sensitive text. The zero value and a
+// nil *Redactor mask nothing.
+type Redactor struct {
+	rules []rule
+}
+
+// New creates a Redactor for the given categories (see Categories)
+func New(categories ...string) (*Redactor, error) {
+	r := &Redactor{}
+	enabled := map[string]bool{}
+	for _, c := range categories {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if _, ok := rules[c]; !ok {
+			return nil, fmt.Errorf("unknown redaction category %q (%s)", c, strings.Join(Categories, ", "))
+		}
+		enabled[c] = true
+	}
+	for _, c := range Categories {
+		if enabled[c] {
+			r.rules = append(r.rules, rules[c]...)
+		}
+	}
+	return r, nil
+}
+
+// ParseCategories creates a Redactor from a comma-separated list of categories
+func ParseCategories(list string) (*Redactor, error) {
+	return New(strings.Split(list, ",")...)
+}
+
+// AddPatterns adds user-defined regular expressions whose matches are masked
+func (r *Redactor) AddPatterns(patterns ...string) error {
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid redaction pattern %q: %v", pattern, err)
+		}
+		r.rules = append(r.rules, rule{re: re, replacement: "[REDACTED:custom]"})
+	}
+	return nil
+}
+
+// Enabled reports whether r masks anything
+func (r *Redactor) Enabled() bool {
+	return r != nil && len(r.rules) > 0
+}
+
+// String returns s with every match masked
+func (r *Redactor) String(s string) string {
+	if !r.Enabled() {
+		return s
+	}
+	for _, rule := range r.rules {
+		s = rule.apply(s)
+	}
+	return s
+}
+
+// JSON masks every string of the JSON document raw (keys are kept). A document
+// that cannot be parsed is masked as text and returned as a JSON string.
+func (r *Redactor) JSON(raw []byte) []byte {
+	if !r.Enabled() {
+		return raw
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		out, _ := json.Marshal(r.String(string(raw)))
+		return out
+	}
+	out, err := json.Marshal(r.value(v))
+	if err != nil {
+		out, _ = json.Marshal(r.String(string(raw)))
+	}
+	return out
+}
+
+func (r *Redactor) value(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.String(v)
+	case []any:
+		for i := range v {
+			v[i] = r.value(v[i])
+		}
+	case map[string]any:
+		for key := range v {
+			v[key] = r.value(v[key])
+		}
+	}
+	return v
+}
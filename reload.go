@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"claude-copilot/api"
+	"claude-copilot/config"
+	"claude-copilot/logging"
+)
+
+// reloadInterval is how often the config file is checked for changes
+const reloadInterval = 2 * time.Second
+
+// reloadableKeys are settings applied to the running proxy; changing any other
+// setting only takes effect after a restart. Inbound API keys are always re-read
+// by the key store itself.
+var reloadableKeys = map[string]bool{
+	"debug":                   true,
+	"log_level":               true,
+	"model":                   true,
+	"model_aliases":           true,
+	"require_api_key":         true,
+	"max_concurrent_requests": true,
+	"max_queued_requests":     true,
+	"upstream_retries":        true,
+	"budget_daily_soft":       true,
+	"budget_daily_hard":       true,
+	"budget_monthly_soft":     true,
+	"budget_monthly_hard":     true,
+	"key_budgets":             true,
+}
+
+// reloader re-reads the configuration on SIGHUP or when the config file changes
+type reloader struct {
+	args    []string // the proxy's command-line arguments, re-applied on top of the file
+	handler *api.Handler
+
+	current map[string]string // settings in effect
+	modTime time.Time
+}
+
+func newReloader(args []string, f *serveFlags, cfg *config.AppConfig, handler *api.Handler) *reloader {
+	r := &reloader{args: args, handler: handler, current: settingsSnapshot(f, cfg)}
+	r.modTime, _ = configModTime()
+	return r
+}
+
+func (r *reloader) run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.reload(true)
+		case <-ticker.C:
+			if modTime, err := configModTime(); err == nil && !modTime.Equal(r.modTime) {
+				r.reload(false)
+			}
+		}
+	}
+}
+
+// reload loads the configuration as on startup and applies what changed.
+// An invalid configuration is reported and the running settings are kept.
+func (r *reloader) reload(requested bool) {
+	r.modTime, _ = configModTime()
+
+	f := defineServeFlags(flag.NewFlagSet("reload", flag.ContinueOnError))
+	f.fs.SetOutput(io.Discard)
+	if err := f.fs.Parse(r.args); err != nil {
+		slog.Error("設定の再読み込みに失敗しました", "error", err)
+		return
+	}
+	cfg, err := f.reloadConfig()
+	if err != nil {
+		slog.Error("設定の再読み込みに失敗しました（現在の設定を維持します）", "error", err)
+		return
+	}
+	for _, key := range cfg.UnknownKeys() {
+		slog.Warn("設定ファイルの不明なキーを無視します", "key", key)
+	}
+
+	next := settingsSnapshot(f, cfg)
+	var changed []string
+	for key, value := range next {
+		if r.current[key] != value {
+			changed = append(changed, key)
+		}
+	}
+	if len(changed) == 0 {
+		if requested {
+			slog.Info("設定を再読み込みしました（変更なし）")
+		}
+		return
+	}
+	sort.Strings(changed)
+
+	for _, key := range changed {
+		if reloadableKeys[key] {
+			slog.Info("設定を再読み込みしました", "key", key, "old", valueOrDash(r.current[key]), "new", valueOrDash(next[key]))
+			r.current[key] = next[key]
+		} else {
+			// Keep reporting it until the proxy is restarted
+			slog.Warn("設定の変更の反映には再起動が必要です", "key", key, "old", valueOrDash(r.current[key]), "new", valueOrDash(next[key]))
+		}
+	}
+
+	r.handler.UpdateSettings(f.handlerSettings(cfg))
+	logging.Level.Set(f.minLogLevel())
+	r.handler.Limiter.SetLimits(*f.maxConcurrent, *f.maxQueued)
+}
+
+// settingsSnapshot returns the effective value of every setting, by config key
+func settingsSnapshot(f *serveFlags, cfg *config.AppConfig) map[string]string {
+	snapshot := map[string]string{
+		"model_aliases":   formatAliases(cfg.ModelAliases),
+		"redact_patterns": strings.Join(cfg.RedactPatterns, " "),
+		"key_budgets":     formatKeyBudgets(cfg.KeyBudgets),
+	}
+	f.fs.VisitAll(func(fl *flag.Flag) {
+		if !transientFlags[fl.Name] {
+			snapshot[optionKey(fl.Name)] = f.effectiveValue(cfg, fl.Name)
+		}
+	})
+	return snapshot
+}
+
+func configModTime() (time.Time, error) {
+	info, err := os.Stat(config.GetConfigPath())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	copilot "github.com/github/copilot-sdk/go"
+
+	"claude-copilot/metrics"
+)
+
+const (
+	// cliCheckInterval is how often superviseCLI checks the Copilot CLIs
+	cliCheckInterval = 30 * time.Second
+	// cliPingTimeout is how long a CLI gets to answer a ping
+	cliPingTimeout = 10 * time.Second
+	// cliMaxMissedPings is how many checks in a row a CLI may let a ping time
+	// out before it is restarted; a busy CLI may miss one
+	cliMaxMissedPings = 2
+)
+
+// errPingTimeout is returned by checkCLI when the CLI did not answer in time
+var errPingTimeout = fmt.Errorf("no answer within %s", cliPingTimeout)
+
+var (
+	cliRestarts = metrics.NewCounterVec("claude_copilot_cli_restarts_total",
+		"Copilot CLI restarts after it exited or stopped answering.")
+	tokenRefreshes = metrics.NewCounterVec("claude_copilot_token_refreshes_total",
+		"Copilot token refreshes by result (success or error).", "result")
+)
+
+// countTokenRefresh is the TokenSource.OnRefresh hook
+func countTokenRefresh(err error) {
+	if err != nil {
+		tokenRefreshes.Inc("error")
+		return
+	}
+	tokenRefreshes.Inc("success")
+}
+
+// superviseCLI checks the Copilot CLIs periodically and restarts those that
+// have exited or stop answering, until ctx is done. clients returns the clients
+// to watch by profile ("" for the default one). The SDK does not notice a CLI
+// that crashed (the client stays connected), so the CLI is pinged: an exited
+// one fails the ping at once and is restarted, a slow one only after
+// cliMaxMissedPings checks.
+func superviseCLI(ctx context.Context, clients func() map[string]*copilot.Client) {
+	ticker := time.NewTicker(cliCheckInterval)
+	defer ticker.Stop()
+	missed := map[string]int{} // pings missed in a row by profile
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for profile, client := range clients() {
+			err := checkCLI(client)
+			if err == nil {
+				delete(missed, profile)
+				continue
+			}
+			if ctx.Err() != nil {
+				return // stopped for shutdown
+			}
+			missed[profile]++
+			if errors.Is(err, errPingTimeout) && missed[profile] < cliMaxMissedPings {
+				slog.Warn("Copilot CLI が応答しません", "profile", profile, "missed", missed[profile], "error", err)
+				continue
+			}
+			slog.Warn("Copilot CLI が停止しています。再起動します", "profile", profile, "error", err)
+			client.Stop()
+			if err := client.Start(ctx); err != nil {
+				slog.Error("Copilot CLI の再起動に失敗しました", "profile", profile, "retry_in", cliCheckInterval.String(), "error", err)
+				continue
+			}
+			delete(missed, profile)
+			cliRestarts.Inc()
+			slog.Info("Copilot CLI を再起動しました", "profile", profile)
+		}
+	}
+}
+
+// checkCLI reports why client cannot serve requests: its state has failed or
+// the CLI does not answer a ping in time (Client.Ping does not honor its context)
+func checkCLI(client *copilot.Client) error {
+	if state := client.State(); state != copilot.StateConnected {
+		return fmt.Errorf("client is %s", state)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		_, err := client.Ping(context.Background(), "supervisor")
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(cliPingTimeout):
+		return errPingTimeout
+	}
+}
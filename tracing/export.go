@@ -0,0 +1,311 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// batchSize is the number of spans sent per export
+	batchSize = 256
+	// flushInterval is how long ended spans wait at most before they are exported
+	flushInterval = 5 * time.Second
+	// queueSize is the number of ended spans buffered; spans beyond it are dropped
+	queueSize = 4096
+	// exportTimeout bounds one request to the collector
+	exportTimeout = 10 * time.Second
+)
+
+// Options configure the exporter. Spans go to the collector at Endpoint when it
+// is set, otherwise to File.
+type Options struct {
+	Endpoint       string // OTLP/HTTP base URL of a collector (e.g. http://localhost:4318)
+	File           string // file receiving one OTLP/JSON export request per line
+	ServiceName    string
+	ServiceVersion string
+}
+
+// exporter writes an OTLP/JSON ExportTraceServiceRequest
+type exporter interface {
+	export(ctx context.Context, body []byte) error
+	close() error
+}
+
+type tracer struct {
+	opts     Options
+	exporter exporter
+	dropped  atomic.Int64
+	done     chan struct{}
+
+	mu     sync.RWMutex
+	queue  chan *Span // closed by Shutdown
+	closed bool
+}
+
+var active atomic.Pointer[tracer]
+
+// Enable starts recording spans and exporting them in the background
+func Enable(opts Options) error {
+	var exp exporter
+	switch {
+	case opts.Endpoint != "":
+		url := strings.TrimSuffix(opts.Endpoint, "/")
+		if !strings.HasSuffix(url, "/v1/traces") {
+			url += "/v1/traces"
+		}
+		exp = &otlpExporter{url: url, client: &http.Client{Timeout: exportTimeout}}
+	case opts.File != "":
+		if err := os.MkdirAll(filepath.Dir(opts.File), 0700); err != nil {
+			return err
+		}
+		file, err := os.OpenFile(opts.File, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			return err
+		}
+		exp = &fileExporter{file: file}
+	default:
+		return fmt.Errorf("no trace endpoint or file")
+	}
+	if opts.ServiceName == "" {
+		opts.ServiceName = "claude-copilot"
+	}
+
+	t := &tracer{opts: opts, exporter: exp, queue: make(chan *Span, queueSize), done: make(chan struct{})}
+	go t.run()
+	active.Store(t)
+	return nil
+}
+
+// Shutdown stops recording spans and exports the ones still queued, waiting
+// until ctx is done at most
+func Shutdown(ctx context.Context) error {
+	t := active.Swap(nil)
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	t.closed = true
+	close(t.queue)
+	t.mu.Unlock()
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return t.exporter.close()
+}
+
+func (t *tracer) enqueue(s *Span) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.closed {
+		return // ended after Shutdown
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run batches ended spans and exports them until the queue is closed
+func (t *tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	flush := func() {
+		if dropped := t.dropped.Swap(0); dropped > 0 {
+			slog.Warn("トレースのキューが一杯のためスパンを破棄しました", "spans", dropped)
+		}
+		if len(batch) == 0 {
+			return
+		}
+		body, err := json.Marshal(t.request(batch))
+		batch = nil
+		if err != nil {
+			slog.Warn("トレースのエンコードに失敗しました", "error", err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		defer cancel()
+		if err := t.exporter.export(ctx, body); err != nil {
+			slog.Warn("トレースのエクスポートに失敗しました", "error", err)
+		}
+	}
+	for {
+		select {
+		case s, ok := <-t.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// OTLP/JSON encoding (opentelemetry-proto, JSON mapping: IDs in hex, 64-bit
+// integers as strings)
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name    string `json:"name"`
+		Version string `json:"version,omitempty"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              SpanKind       `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"` // 2 = error
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+func (t *tracer) request(spans []*Span) *otlpRequest {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = s.encode()
+	}
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			encodeAttr(String("service.name", t.opts.ServiceName)),
+			encodeAttr(String("service.version", t.opts.ServiceVersion)),
+		}},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: t.opts.ServiceName, Version: t.opts.ServiceVersion},
+			Spans: encoded,
+		}},
+	}}}
+}
+
+func (s *Span) encode() otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := otlpSpan{
+		TraceID:           hex.EncodeToString(s.sc.traceID[:]),
+		SpanID:            hex.EncodeToString(s.sc.spanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, a := range s.attrs {
+		span.Attributes = append(span.Attributes, encodeAttr(a))
+	}
+	if s.errMsg != "" {
+		span.Status = otlpStatus{Code: 2, Message: s.errMsg}
+	}
+	return span
+}
+
+func encodeAttr(a Attr) otlpKeyValue {
+	kv := otlpKeyValue{Key: a.Key}
+	switch v := a.Value.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+// otlpExporter posts spans to an OTLP/HTTP collector
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func (e *otlpExporter) export(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() error { return nil }
+
+// fileExporter appends spans to a file in the format of the collector's file
+// exporter, so it can be replayed with its otlpjsonfile receiver
+type fileExporter struct {
+	file *os.File
+}
+
+func (e *fileExporter) export(_ context.Context, body []byte) error {
+	_, err := e.file.Write(append(body, '\n'))
+	return err
+}
+
+func (e *fileExporter) close() error { return e.file.Close() }
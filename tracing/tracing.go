@@ -0,0 +1,174 @@
+// Package tracing records OpenTelemetry spans of the proxy's request pipeline
+// (HTTP request → prompt translation → Copilot session → CLI) and exports them
+// as OTLP/JSON, to a collector over HTTP or to a file. It implements the small
+// part of OpenTelemetry the proxy needs with the standard library only.
+//
+// Tracing is off until Enable is called; until then Start returns a nil *Span,
+// whose methods do nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanKind is the OpenTelemetry span kind
+type SpanKind int
+
+// Span kinds (the values of the OTLP enum)
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// Attr is a span attribute. Values are strings, bools, ints or float64s.
+type Attr struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, value string) Attr { return Attr{key, value} }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attr { return Attr{key, int64(value)} }
+
+// Float64 returns a floating-point attribute
+func Float64(key string, value float64) Attr { return Attr{key, value} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attr { return Attr{key, value} }
+
+// spanContext identifies a span within a trace
+type spanContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// Span is one timed operation. It is safe for concurrent use; a nil *Span
+// (tracing disabled) ignores every call.
+type Span struct {
+	tracer *tracer
+	sc     spanContext
+	parent [8]byte // zero for a root span
+	name   string
+	kind   SpanKind
+	start  time.Time
+
+	mu     sync.Mutex
+	end    time.Time
+	attrs  []Attr
+	errMsg string
+}
+
+type spanKey struct{}
+
+// Start starts a span as a child of the span (or remote parent) in ctx and
+// returns a context carrying it
+func Start(ctx context.Context, name string, kind SpanKind, attrs ...Attr) (context.Context, *Span) {
+	t := active.Load()
+	if t == nil {
+		return ctx, nil
+	}
+	s := &Span{tracer: t, name: name, kind: kind, start: time.Now(), attrs: attrs}
+	if parent, ok := ctx.Value(spanKey{}).(spanContext); ok {
+		s.sc.traceID, s.parent = parent.traceID, parent.spanID
+	} else {
+		rand.Read(s.sc.traceID[:])
+	}
+	rand.Read(s.sc.spanID[:])
+	return context.WithValue(ctx, spanKey{}, s.sc), s
+}
+
+// SetAttributes adds attributes to the span (replacing those with the same key)
+func (s *Span) SetAttributes(attrs ...Attr) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		replaced := false
+		for i := range s.attrs {
+			if s.attrs[i].Key == a.Key {
+				s.attrs[i], replaced = a, true
+				break
+			}
+		}
+		if !replaced {
+			s.attrs = append(s.attrs, a)
+		}
+	}
+}
+
+// SetError marks the span as failed (a nil err is ignored)
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errMsg = err.Error()
+}
+
+// End ends the span and queues it for export. Only the first call counts.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
+
+// TraceID returns the trace ID of the span in ctx (hex), or "" when there is none
+func TraceID(ctx context.Context) string {
+	sc, ok := ctx.Value(spanKey{}).(spanContext)
+	if !ok {
+		return ""
+	}
+	return hex.EncodeToString(sc.traceID[:])
+}
+
+// TraceparentHeader is the W3C Trace Context header carrying the caller's span
+const TraceparentHeader = "traceparent"
+
+// WithRemoteParent returns a copy of ctx whose spans continue the trace of a W3C
+// traceparent header value (version-traceid-spanid-flags). Invalid values are
+// ignored, so the request starts a new trace.
+func WithRemoteParent(ctx context.Context, traceparent string) context.Context {
+	sc, err := parseTraceparent(traceparent)
+	if err != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, spanKey{}, sc)
+}
+
+func parseTraceparent(value string) (spanContext, error) {
+	var sc spanContext
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	if _, err := hex.Decode(sc.traceID[:], []byte(parts[1])); err != nil {
+		return sc, err
+	}
+	if _, err := hex.Decode(sc.spanID[:], []byte(parts[2])); err != nil {
+		return sc, err
+	}
+	if sc.traceID == [16]byte{} || sc.spanID == [8]byte{} {
+		return sc, fmt.Errorf("invalid traceparent %q", value)
+	}
+	return sc, nil
+}
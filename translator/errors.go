@@ -0,0 +1,205 @@
+package translator
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrorKind classifies the failures reported by Copilot
+type ErrorKind string
+
+// Error kinds
+const (
+	KindRateLimit  ErrorKind = "rate_limit" // too many requests; retry later
+	KindOverloaded ErrorKind = "overloaded" // Copilot or the model is unavailable for now
+	KindQuota      ErrorKind = "quota"      // the premium request quota is spent; retrying does not help
+	KindAuth       ErrorKind = "auth"       // the GitHub token is invalid or expired
+	KindPermission ErrorKind = "permission" // the account lacks access to Copilot or the model
+	KindFatal      ErrorKind = "fatal"      // anything else; retrying does not help
+)
+
+// StatusOverloaded is the status Anthropic uses for overloaded_error
+const StatusOverloaded = 529
+
+// UpstreamError is a failure reported by Copilot before the response started, so
+// it can still be retried or answered with an HTTP error
+type UpstreamError struct {
+	Kind       ErrorKind
+	Type       string        // the errorType of the SessionError event
+	Message    string        // the message reported by Copilot
+	StatusCode int           // the upstream HTTP status, when reported
+	RetryAfter time.Duration // when to retry, when reported
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("copilot %s error: %s", e.Kind, e.Message)
+}
+
+// Transient reports whether retrying the request may succeed
+func (e *UpstreamError) Transient() bool {
+	return e.Kind == KindRateLimit || e.Kind == KindOverloaded
+}
+
+// Status returns the HTTP status Anthropic uses for the error
+func (e *UpstreamError) Status() int {
+	switch e.Kind {
+	case KindRateLimit:
+		return http.StatusTooManyRequests
+	case KindOverloaded:
+		return StatusOverloaded
+	case KindQuota:
+		return http.StatusPaymentRequired
+	case KindAuth:
+		return http.StatusUnauthorized
+	case KindPermission:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// AnthropicType returns the Anthropic error type of the error
+func (e *UpstreamError) AnthropicType() string {
+	switch e.Kind {
+	case KindRateLimit:
+		return "rate_limit_error"
+	case KindOverloaded:
+		return "overloaded_error"
+	case KindQuota:
+		return "billing_error"
+	case KindAuth:
+		return "authentication_error"
+	case KindPermission:
+		return "permission_error"
+	}
+	return "api_error"
+}
+
+// AnthropicMessage returns the message sent to the client
+func (e *UpstreamError) AnthropicMessage() string {
+	switch e.Kind {
+	case KindQuota:
+		return "Copilot quota exceeded: " + e.Message + " (retrying will not help until the quota resets)"
+	case KindAuth:
+		return "Copilot rejected the credentials: " + e.Message + " (run `claude-copilot login` to sign in again)"
+	case KindPermission:
+		return "Copilot denied access: " + e.Message + " (check the Copilot subscription and model policy)"
+	}
+	return "Copilot error: " + e.Message
+}
+
+// retryAfterPattern finds the delay in messages such as "Please retry after 12
+// seconds" or "try again in 1m30s"
+var retryAfterPattern = regexp.MustCompile(`(?i)(?:retry|try again)\s+(?:after|in)\s+([0-9.]+)\s*(ms|milliseconds?|s|secs?|seconds?|m|mins?|minutes?)?\b`)
+
+// classify builds the UpstreamError of a SessionError event from its error type,
+// message and status code (each optional)
+func classify(errorType, message *string, statusCode *int64) *UpstreamError {
+	e := &UpstreamError{Type: deref(errorType), Message: deref(message)}
+	if e.Message == "" {
+		e.Message = "unknown error"
+	}
+	if statusCode != nil {
+		e.StatusCode = int(*statusCode)
+	}
+	e.Kind = classifyKind(e.StatusCode, strings.ToLower(e.Type+" "+e.Message))
+	if m := retryAfterPattern.FindStringSubmatch(e.Message); m != nil {
+		if n, err := strconv.ParseFloat(m[1], 64); err == nil {
+			unit := time.Second
+			switch {
+			case strings.HasPrefix(m[2], "ms") || strings.HasPrefix(m[2], "milli"):
+				unit = time.Millisecond
+			case strings.HasPrefix(m[2], "m"):
+				unit = time.Minute
+			}
+			e.RetryAfter = time.Duration(n * float64(unit))
+		}
+	}
+	return e
+}
+
+func classifyKind(status int, text string) ErrorKind {
+	containsAny := func(words ...string) bool {
+		for _, w := range words {
+			if strings.Contains(text, w) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	// A spent quota comes as 429 too, but does not reset within a retry
+	case status == http.StatusPaymentRequired || containsAny("quota", "billing", "spending limit"):
+		return KindQuota
+	case status == http.StatusTooManyRequests || containsAny("rate limit", "rate_limit", "ratelimit", "too many requests"):
+		return KindRateLimit
+	case status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout ||
+		status == StatusOverloaded || containsAny("overloaded", "capacity", "unavailable", "timeout", "timed out"):
+		return KindOverloaded
+	case status == http.StatusUnauthorized ||
+		(status != http.StatusForbidden && containsAny("unauthorized", "authentication", "bad credentials", "token expired", "expired token", "invalid token")):
+		return KindAuth
+	case status == http.StatusForbidden || containsAny("forbidden", "not authorized", "access denied", "permission"):
+		return KindPermission
+	}
+	return KindFatal
+}
+
+const (
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff between attempts
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 8 * time.Second
+	// maxRetryAfter is the longest retry-after the proxy waits for itself; longer
+	// ones are passed to the client
+	maxRetryAfter = 10 * time.Second
+)
+
+// retryDelay returns how long to wait before retry n (from 1): the upstream
+// retry-after when reported, otherwise exponential backoff with jitter. It
+// reports false when the wait is too long to retry within the request.
+func retryDelay(n int, retryAfter time.Duration) (time.Duration, bool) {
+	if retryAfter > 0 {
+		return retryAfter, retryAfter <= maxRetryAfter
+	}
+	d := min(retryBaseDelay<<(n-1), retryMaxDelay)
+	return d/2 + rand.N(d/2+1), true
+}
+
+const (
+	// retryRatio is the share of requests that may be retried
+	retryRatio = 0.2
+	// maxRetryTokens bounds the retries available after a quiet period
+	maxRetryTokens = 10
+)
+
+// retryBudget limits retries to a share of the requests, so retrying does not
+// multiply the load while Copilot is rate limiting or overloaded
+type retryBudget struct {
+	mu     sync.Mutex
+	tokens float64
+}
+
+var retries = &retryBudget{tokens: maxRetryTokens}
+
+// deposit credits one request
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+retryRatio, maxRetryTokens)
+}
+
+// withdraw takes one retry from the budget, reporting false when it is spent
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
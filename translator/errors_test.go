@@ -0,0 +1,99 @@
+package translator
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		errorType, message string
+		status             int64
+		kind               ErrorKind
+		retryAfter         time.Duration
+		httpStatus         int
+		anthropicType      string
+	}{
+		{"", "Rate limit exceeded. Please retry after 12 seconds.", 0, KindRateLimit, 12 * time.Second, http.StatusTooManyRequests, "rate_limit_error"},
+		{"", "too many requests, try again in 1.5m", 0, KindRateLimit, 90 * time.Second, http.StatusTooManyRequests, "rate_limit_error"},
+		{"", "slow down", 429, KindRateLimit, 0, http.StatusTooManyRequests, "rate_limit_error"},
+		{"", "retry after 250ms", 503, KindOverloaded, 250 * time.Millisecond, StatusOverloaded, "overloaded_error"},
+		{"", "The model is overloaded", 0, KindOverloaded, 0, StatusOverloaded, "overloaded_error"},
+		{"", "request timed out", 0, KindOverloaded, 0, StatusOverloaded, "overloaded_error"},
+		{"", "You have exceeded your premium request quota", 429, KindQuota, 0, http.StatusPaymentRequired, "billing_error"},
+		{"", "monthly spending limit reached", 0, KindQuota, 0, http.StatusPaymentRequired, "billing_error"},
+		{"", "Bad credentials", 401, KindAuth, 0, http.StatusUnauthorized, "authentication_error"},
+		{"authentication", "token expired", 0, KindAuth, 0, http.StatusUnauthorized, "authentication_error"},
+		{"", "unauthorized: access denied by policy", 403, KindPermission, 0, http.StatusForbidden, "permission_error"},
+		{"", "Forbidden", 0, KindPermission, 0, http.StatusForbidden, "permission_error"},
+		{"", "model not supported", 400, KindFatal, 0, http.StatusInternalServerError, "api_error"},
+		{"", "", 0, KindFatal, 0, http.StatusInternalServerError, "api_error"},
+	}
+	for _, tt := range tests {
+		var status *int64
+		if tt.status != 0 {
+			status = &tt.status
+		}
+		e := classify(&tt.errorType, &tt.message, status)
+		if e.Kind != tt.kind || e.RetryAfter != tt.retryAfter {
+			t.Errorf("classify(%q, %q, %d) = %s after %v, want %s after %v",
+				tt.errorType, tt.message, tt.status, e.Kind, e.RetryAfter, tt.kind, tt.retryAfter)
+		}
+		if e.Status() != tt.httpStatus || e.AnthropicType() != tt.anthropicType {
+			t.Errorf("classify(%q): %d %s, want %d %s", tt.message, e.Status(), e.AnthropicType(), tt.httpStatus, tt.anthropicType)
+		}
+		if want := tt.kind == KindRateLimit || tt.kind == KindOverloaded; e.Transient() != want {
+			t.Errorf("classify(%q).Transient() = %v, want %v", tt.message, e.Transient(), want)
+		}
+	}
+
+	if e := classify(nil, nil, nil); e.Kind != KindFatal || e.Message != "unknown error" {
+		t.Errorf("classify(nil) = %+v", e)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for n := 1; n <= 8; n++ {
+		backoff := min(retryBaseDelay<<(n-1), retryMaxDelay)
+		for range 50 {
+			d, ok := retryDelay(n, 0)
+			if !ok || d < backoff/2 || d > backoff {
+				t.Fatalf("retryDelay(%d, 0) = %v, %v, want %v-%v", n, d, ok, backoff/2, backoff)
+			}
+		}
+	}
+	if d, ok := retryDelay(1, 3*time.Second); d != 3*time.Second || !ok {
+		t.Errorf("retryDelay(1, 3s) = %v, %v", d, ok)
+	}
+	if d, ok := retryDelay(1, maxRetryAfter+time.Second); d != maxRetryAfter+time.Second || ok {
+		t.Errorf("retryDelay(1, too long) = %v, %v, want false", d, ok)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := &retryBudget{tokens: 2}
+	if !b.withdraw() || !b.withdraw() {
+		t.Fatal("withdraw failed with tokens left")
+	}
+	if b.withdraw() {
+		t.Fatal("withdraw succeeded with an empty budget")
+	}
+	// Every request earns a fifth of a retry
+	for range 4 {
+		b.deposit()
+	}
+	if b.withdraw() {
+		t.Fatal("withdraw succeeded after 4 requests")
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatal("withdraw failed after 5 requests")
+	}
+	for range 1000 {
+		b.deposit()
+	}
+	if b.tokens != maxRetryTokens {
+		t.Errorf("tokens = %v after many requests, want %v", b.tokens, maxRetryTokens)
+	}
+}
@@ -0,0 +1,37 @@
+package translator
+
+import (
+	"claude-copilot/metrics"
+)
+
+// maxModelLabels bounds the model label: requests can name any model, so only
+// the first distinct names get their own series
+const maxModelLabels = 32
+
+var modelLabels = metrics.NewBounded(maxModelLabels)
+
+var (
+	sessionsCreated = metrics.NewCounterVec("claude_copilot_sessions_created_total",
+		"Copilot sessions created.")
+	sessionsDestroyed = metrics.NewCounterVec("claude_copilot_sessions_destroyed_total",
+		"Copilot sessions destroyed.")
+	sessionErrors = metrics.NewCounterVec("claude_copilot_session_errors_total",
+		"Copilot session failures by stage (create, send or session).", "stage")
+	upstreamErrors = metrics.NewCounterVec("claude_copilot_upstream_errors_total",
+		"Copilot session errors by kind (rate_limit, overloaded, quota, auth, permission or fatal).", "kind")
+	upstreamRetries = metrics.NewCounterVec("claude_copilot_upstream_retries_total",
+		"Requests retried after a transient Copilot error, by kind (rate_limit or overloaded).", "kind")
+	timeToFirstToken = metrics.NewHistogramVec("claude_copilot_time_to_first_token_seconds",
+		"Time from sending the prompt to the first response text.", metrics.DefBuckets, "model")
+	tokens = metrics.NewCounterVec("claude_copilot_tokens_total",
+		"Tokens reported by Copilot by model and type (input or output).", "model", "type")
+)
+
+// ModelLabel returns the metrics label for a model name, keeping the number of
+// distinct values bounded
+func ModelLabel(model string) string {
+	if model == "" {
+		model = defaultModel
+	}
+	return modelLabels.Value(model)
+}
@@ -3,39 +3,126 @@ package translator
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"claude-copilot/models"
+	"claude-copilot/tracing"
 
 	copilot "github.com/github/copilot-sdk/go"
 )
 
-// HandleChatRequest processes incoming Anthropic requests and proxies them via the Copilot SDK Session
-func HandleChatRequest(ctx context.Context, copilotClient *copilot.Client, anthropicReq *models.AnthropicRequest, w http.ResponseWriter) error {
+// Result summarizes how a proxied request completed
+type Result struct {
+	// SessionError holds the message of a SessionError event, if one occurred
+	SessionError string
+
+	Model           string // the model that served the turn, as reported by Copilot
+	InputTokens     int
+	OutputTokens    int
+	PremiumRequests *float64 // the cost reported by Copilot (nil = not reported)
+	StopReason      string   // set when the turn completed
+	Prompt          string   // the prompt sent to Copilot
+	Completion      string   // the text received
+}
+
+// defaultModel is used when a request reaches the translator without a model
+const defaultModel = "GPT-5 mini"
+
+// ErrShuttingDown is the cancellation cause of requests aborted because the proxy
+// is stopping. Streams aborted this way end with an overloaded_error event.
+var ErrShuttingDown = errors.New("the proxy is shutting down")
+
+// HandleChatRequest processes incoming Anthropic requests and proxies them via the Copilot SDK Session.
+// Transient upstream failures (rate limits, overloads) that occur before the first
+// token are retried up to maxRetries times; otherwise they are returned as an
+// *UpstreamError for the caller to answer.
+func HandleChatRequest(ctx context.Context, copilotClient *copilot.Client, anthropicReq *models.AnthropicRequest, w http.ResponseWriter, maxRetries int) (*Result, error) {
 	modelName := anthropicReq.Model
 	if modelName == "" {
-		modelName = "GPT-5 mini"
+		modelName = defaultModel
 	}
 
+	modelAttr := tracing.String("gen_ai.request.model", modelName)
+	fullPrompt := buildPrompt(ctx, anthropicReq, modelAttr)
+
+	retries.deposit()
+	for attempt := 1; ; attempt++ {
+		result, err := runTurn(ctx, copilotClient, anthropicReq, modelName, fullPrompt, w, modelAttr)
+		var upstream *UpstreamError
+		if !errors.As(err, &upstream) || !upstream.Transient() || attempt > maxRetries {
+			return result, err
+		}
+		delay, ok := retryDelay(attempt, upstream.RetryAfter)
+		if !ok || !retries.withdraw() {
+			return result, err
+		}
+		upstreamRetries.Inc(string(upstream.Kind))
+		slog.WarnContext(ctx, "Copilot の一時的なエラーのため再試行します", "kind", upstream.Kind,
+			"attempt", attempt, "delay", delay, "error", upstream.Message)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return result, context.Cause(ctx)
+		}
+	}
+}
+
+// runTurn serves one attempt at the request in a new Copilot session
+func runTurn(ctx context.Context, copilotClient *copilot.Client, anthropicReq *models.AnthropicRequest, modelName, prompt string, w http.ResponseWriter, modelAttr tracing.Attr) (*Result, error) {
 	// 1. Create a Session with the Copilot CLI
+	_, span := tracing.Start(ctx, "copilot.create_session", tracing.KindClient, modelAttr)
 	session, err := copilotClient.CreateSession(ctx, &copilot.SessionConfig{
 		Model:               modelName,
 		OnPermissionRequest: copilot.PermissionHandler.ApproveAll,
 		Streaming:           anthropicReq.Stream,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create copilot session: %w", err)
+		span.SetError(err)
+		span.End()
+		sessionErrors.Inc("create")
+		return nil, fmt.Errorf("failed to create copilot session: %w", err)
 	}
-	defer session.Destroy()
+	sessionAttr := tracing.String("copilot.session_id", session.SessionID)
+	span.SetAttributes(sessionAttr)
+	span.End()
+	sessionsCreated.Inc()
+	slog.DebugContext(ctx, "Copilot session created", "session_id", session.SessionID, "model", modelName)
+	defer func() {
+		_, span := tracing.Start(ctx, "copilot.destroy_session", tracing.KindClient, modelAttr, sessionAttr)
+		span.SetError(session.Destroy())
+		span.End()
+		sessionsDestroyed.Inc()
+		slog.DebugContext(ctx, "Copilot session destroyed", "session_id", session.SessionID)
+	}()
+
+	// 2. Run the turn
+	result := &Result{Model: modelName, Prompt: prompt}
+	turn := newTurnObserver(ctx, session, modelName, modelAttr, sessionAttr)
+	defer turn.fill(result)
+	if !anthropicReq.Stream {
+		return result, handleNonStream(ctx, session, prompt, w, result, turn)
+	}
+
+	return result, handleStream(ctx, session, prompt, w, result, turn)
+}
 
-	// 2. Build the aggregate Prompt
+// buildPrompt builds the aggregate prompt of a request
+func buildPrompt(ctx context.Context, anthropicReq *models.AnthropicRequest, modelAttr tracing.Attr) string {
 	// In the Anthropic API, system and user messages are separate.
 	// For the SDK, we send a single MessageOptions request.
 	// We'll concatenate them for the prompt to keep it simple for now, though
 	// ideally the SDK would let us inject full chat history. The SDK `MessageOptions`
 	// primarily takes a single string `Prompt`.
 
+	_, span := tracing.Start(ctx, "translate_prompt", tracing.KindInternal, modelAttr,
+		tracing.Int("claude_copilot.messages", len(anthropicReq.Messages)))
+	defer span.End()
 	fullPrompt := ""
 
 	// Handle System Prompt
@@ -76,108 +163,295 @@ func HandleChatRequest(ctx context.Context, copilotClient *copilot.Client, anthr
 		fullPrompt += fmt.Sprintf("%s: %s\n", msg.Role, contentStr)
 	}
 
-	if !anthropicReq.Stream {
-		return handleNonStream(session, fullPrompt, w)
+	span.SetAttributes(tracing.Int("claude_copilot.prompt_chars", len(fullPrompt)))
+	return fullPrompt
+}
+
+// turnObserver records the metrics and spans of one turn from its session events
+// and logs them
+type turnObserver struct {
+	ctx       context.Context // carries the request ID and span
+	sessionID string
+	model     string // metrics label
+	attrs     []tracing.Attr
+	sentAt    time.Time
+	firstText sync.Once
+
+	mu           sync.Mutex // events arrive on the SDK's goroutine
+	servedModel  string
+	inputTokens  float64
+	outputTokens float64
+	cost         *float64
+	firstDelta   *tracing.Span // from Send to the first text
+	idle         *tracing.Span // from Send to session.idle (or the error)
+	idleEnded    bool
+	sseEvents    int
+	sseWrite     time.Duration // time spent writing deltas to the client
+}
+
+// fill copies what the events reported into result and ends the spans of a turn
+// that did not complete
+func (t *turnObserver) fill(result *Result) {
+	t.endIdle(nil)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.firstDelta.End()
+	if t.servedModel != "" {
+		result.Model = t.servedModel
+	}
+	result.InputTokens, result.OutputTokens = int(t.inputTokens), int(t.outputTokens)
+	result.PremiumRequests = t.cost
+}
+
+// usage returns the model and tokens reported so far, as sent to the client
+func (t *turnObserver) usage(requested string) (string, models.AnthropicUsage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	model := t.servedModel
+	if model == "" {
+		model = requested
+	}
+	return model, models.AnthropicUsage{InputTokens: int(t.inputTokens), OutputTokens: int(t.outputTokens)}
+}
+
+func newTurnObserver(ctx context.Context, session *copilot.Session, model string, attrs ...tracing.Attr) *turnObserver {
+	return &turnObserver{ctx: ctx, sessionID: session.SessionID, model: ModelLabel(model), attrs: attrs, sentAt: time.Now()}
+}
+
+// endIdle ends the idle span with the token and SSE attributes (once)
+func (t *turnObserver) endIdle(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.idleEnded {
+		return
+	}
+	t.idleEnded = true
+	model := t.servedModel
+	if model == "" {
+		model = t.model
 	}
+	t.idle.SetAttributes(
+		tracing.String("gen_ai.response.model", model),
+		tracing.Int("gen_ai.usage.input_tokens", int(t.inputTokens)),
+		tracing.Int("gen_ai.usage.output_tokens", int(t.outputTokens)))
+	if t.sseEvents > 0 {
+		t.idle.SetAttributes(
+			tracing.Int("claude_copilot.sse.events", t.sseEvents),
+			tracing.Float64("claude_copilot.sse.write_ms", float64(t.sseWrite.Microseconds())/1000))
+	}
+	t.idle.SetError(err)
+	t.idle.End()
+}
+
+// wrote records the time taken to write one SSE event to the client
+func (t *turnObserver) wrote(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sseEvents++
+	t.sseWrite += d
+}
+
+func (t *turnObserver) observe(event copilot.SessionEvent) {
+	if event.Type != copilot.AssistantMessageDelta {
+		// Deltas are too many to log one by one
+		slog.DebugContext(t.ctx, "session event", "session_id", t.sessionID, "type", event.Type)
+	}
+	switch event.Type {
+	case copilot.AssistantMessageDelta, copilot.AssistantMessage:
+		t.firstText.Do(func() {
+			timeToFirstToken.Observe(time.Since(t.sentAt).Seconds(), t.model)
+			t.mu.Lock()
+			t.firstDelta.End()
+			t.mu.Unlock()
+		})
+	case copilot.AssistantUsage:
+		t.mu.Lock()
+		if event.Data.Model != nil {
+			t.servedModel = *event.Data.Model
+		}
+		if event.Data.InputTokens != nil {
+			t.inputTokens += *event.Data.InputTokens
+		}
+		if event.Data.OutputTokens != nil {
+			t.outputTokens += *event.Data.OutputTokens
+		}
+		if event.Data.Cost != nil {
+			cost := *event.Data.Cost
+			if t.cost != nil {
+				cost += *t.cost
+			}
+			t.cost = &cost
+		}
+		t.mu.Unlock()
+		if event.Data.InputTokens != nil {
+			tokens.Add(*event.Data.InputTokens, t.model, "input")
+		}
+		if event.Data.OutputTokens != nil {
+			tokens.Add(*event.Data.OutputTokens, t.model, "output")
+		}
+	case copilot.SessionIdle:
+		t.endIdle(nil)
+	case copilot.SessionError:
+		upstream := classify(event.Data.ErrorType, event.Data.Message, event.Data.StatusCode)
+		kind := upstream.Kind
+		sessionErrors.Inc("session")
+		upstreamErrors.Inc(string(kind))
+		level := slog.LevelError
+		if upstream.Transient() {
+			level = slog.LevelWarn // retried or passed on to the client to back off
+		}
+		slog.Log(t.ctx, level, "Copilot session error", "session_id", t.sessionID, "kind", kind,
+			"error_type", deref(event.Data.ErrorType), "error", deref(event.Data.Message))
+		t.endIdle(errors.New(deref(event.Data.Message)))
+	}
+}
+
+// send sends the prompt, counting failures, and starts the spans of the turn
+func (t *turnObserver) send(ctx context.Context, session *copilot.Session, prompt string) error {
+	t.mu.Lock()
+	_, t.firstDelta = tracing.Start(ctx, "copilot.first_delta", tracing.KindInternal, t.attrs...)
+	_, t.idle = tracing.Start(ctx, "copilot.idle", tracing.KindInternal, t.attrs...)
+	t.mu.Unlock()
 
-	return handleStream(session, fullPrompt, w)
+	_, span := tracing.Start(ctx, "copilot.send", tracing.KindClient, t.attrs...)
+	defer span.End()
+	_, err := session.Send(ctx, copilot.MessageOptions{
+		Prompt: prompt,
+	})
+	if err != nil {
+		span.SetError(err)
+		t.endIdle(err)
+		sessionErrors.Inc("send")
+		return fmt.Errorf("failed to send message via sdk: %w", err)
+	}
+	return nil
 }
 
-func handleNonStream(session *copilot.Session, prompt string, w http.ResponseWriter) error {
-	ctx := context.Background()
+// abort stops the turn in the CLI when the request is cancelled (client gone or
+// proxy shutting down) and returns the cancellation cause
+func abort(ctx context.Context, session *copilot.Session) error {
+	session.Abort(context.Background())
+	return context.Cause(ctx)
+}
 
-	var finalResponse string
+func handleNonStream(ctx context.Context, session *copilot.Session, prompt string, w http.ResponseWriter, result *Result, turn *turnObserver) error {
 	done := make(chan struct{})
+	finish := sync.OnceFunc(func() { close(done) }) // an error may be followed by session.idle
+
+	// Events are delivered on the SDK's goroutine; once the turn has ended or the
+	// request is aborted they are ignored
+	var mu sync.Mutex
+	ended := false
+	var finalResponse strings.Builder
+	var upstreamErr *UpstreamError
 
 	// Register event listener
 	unsubscribe := session.On(func(event copilot.SessionEvent) {
+		turn.observe(event)
+		mu.Lock()
+		defer mu.Unlock()
+		if ended {
+			return
+		}
 		switch event.Type {
 		case copilot.AssistantMessage:
 			if event.Data.Content != nil && *event.Data.Content != "" {
-				finalResponse += *event.Data.Content
+				finalResponse.WriteString(*event.Data.Content)
 			}
 		case copilot.SessionIdle:
-			close(done)
+			finish()
 		case copilot.SessionError:
-			errMsg := "unknown error"
-			if event.Data.Message != nil {
-				errMsg = *event.Data.Message
-			}
-			fmt.Printf("Copilot SDK Error: %s\n", errMsg)
-			close(done)
+			upstreamErr = classify(event.Data.ErrorType, event.Data.Message, event.Data.StatusCode)
+			result.SessionError = upstreamErr.Message
+			finish()
 		}
 	})
 	defer unsubscribe()
 
-	_, err := session.Send(ctx, copilot.MessageOptions{
-		Prompt: prompt,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to send message via sdk: %w", err)
+	if err := turn.send(ctx, session, prompt); err != nil {
+		return err
 	}
 
-	<-done
+	select {
+	case <-done:
+	case <-ctx.Done():
+		mu.Lock()
+		ended = true
+		mu.Unlock()
+		return abort(ctx, session)
+	}
+	mu.Lock()
+	ended = true // late events are ignored
+	result.Completion = finalResponse.String()
+	mu.Unlock()
+	if upstreamErr != nil {
+		return upstreamErr // nothing was written yet
+	}
+	result.StopReason = "end_turn"
 
+	model, usage := turn.usage(result.Model)
 	resp := models.AnthropicResponse{
 		ID:    "msg_copilot_sdk_" + session.SessionID,
 		Type:  "message",
 		Role:  "assistant",
-		Model: "GPT-5 mini",
+		Model: model,
 		Content: []models.AnthropicContent{
 			{
 				Type: "text",
-				Text: finalResponse,
+				Text: result.Completion,
 			},
 		},
 		StopReason:   "end_turn",
 		StopSequence: nil,
-		Usage: models.AnthropicUsage{
-			InputTokens:  10, // Mock usage
-			OutputTokens: 10,
-		},
+		Usage:        usage,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(resp)
 }
 
-func handleStream(session *copilot.Session, prompt string, w http.ResponseWriter) error {
+func handleStream(ctx context.Context, session *copilot.Session, prompt string, w http.ResponseWriter, result *Result, turn *turnObserver) error {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		return fmt.Errorf("streaming unsupported")
 	}
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	// Send initial message_start event immediately
-	sendAnthropicEvent(w, flusher, "message_start", models.AnthropicEvent{
-		Type: "message_start",
-		Message: &models.AnthropicMessage{
-			ID:   "msg_copilot_sdk_" + session.SessionID,
-			Type: "message",
-			Role: "assistant",
-		},
-	})
-
-	// Send content block start (raw because of nested interface representation in models)
-	startBlock := "event: content_block_start\n" + `data: {"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}` + "\n\n"
-	fmt.Fprint(w, startBlock)
-	flusher.Flush()
-
-	ctx := context.Background()
-
 	// Create channels to handle sync execution and wait for the finish
 	done := make(chan struct{})
+	finish := sync.OnceFunc(func() { close(done) }) // an error may be followed by session.idle
+
+	// Events are delivered on the SDK's goroutine; once the turn has ended or the
+	// request is aborted nothing more may be written to w. The response starts
+	// with the first text, so upstream errors before it can still be retried or
+	// answered with an HTTP error.
+	var mu sync.Mutex
+	aborted := false
+	started := false
+	var completion strings.Builder
+	var upstreamErr *UpstreamError
+	start := func() {
+		if !started {
+			started = true
+			startStream(w, flusher, session.SessionID, result.Model)
+		}
+	}
 
 	// Step 3: Register Session Event Listeners
 	unsubscribe := session.On(func(event copilot.SessionEvent) {
+		turn.observe(event)
+		mu.Lock()
+		defer mu.Unlock()
+		if aborted {
+			return
+		}
 		switch event.Type {
 		// Event: Assistant is streaming text back
 		// We subscribe to AssistantMessageDelta to receive chunks progressively instead of waiting for the full AssistantMessage.
 		case copilot.AssistantMessageDelta:
 			if event.Data.DeltaContent != nil && *event.Data.DeltaContent != "" {
+				start()
+				completion.WriteString(*event.Data.DeltaContent)
+				writeStart := time.Now()
 				sendAnthropicEvent(w, flusher, "content_block_delta", models.AnthropicEvent{
 					Type:  "content_block_delta",
 					Index: 0,
@@ -186,45 +460,70 @@ func handleStream(session *copilot.Session, prompt string, w http.ResponseWriter
 						Text: *event.Data.DeltaContent,
 					},
 				})
+				turn.wrote(time.Since(writeStart))
 			}
 
 		case copilot.SessionIdle:
 			// Stream finished
-			close(done)
+			finish()
 		case copilot.SessionError:
-			errMsg := "unknown error"
-			if event.Data.Message != nil {
-				errMsg = *event.Data.Message
-			}
-			fmt.Printf("Copilot SDK Error: %s\n", errMsg)
-			close(done)
+			upstreamErr = classify(event.Data.ErrorType, event.Data.Message, event.Data.StatusCode)
+			result.SessionError = upstreamErr.Message
+			finish()
 		}
 	})
 	defer unsubscribe()
 
 	// Send the prompt. The SDK will asynchronously fire the SessionEvent handlers above.
-	_, err := session.Send(ctx, copilot.MessageOptions{
-		Prompt: prompt,
-	})
-
-	if err != nil {
-		return fmt.Errorf("failed to send message via sdk: %w", err)
+	if err := turn.send(ctx, session, prompt); err != nil {
+		return err
 	}
 
 	// Wait for the stream to finish mapping
-	<-done
+	select {
+	case <-done:
+	case <-ctx.Done():
+		mu.Lock()
+		aborted = true
+		result.Completion = completion.String()
+		mu.Unlock()
+		err := abort(ctx, session)
+		if errors.Is(err, ErrShuttingDown) {
+			start()
+			sendErrorEvent(w, flusher, "overloaded_error", "The proxy is shutting down; please retry the request")
+		}
+		return err
+	}
+	mu.Lock()
+	aborted = true // late events are ignored
+	result.Completion = completion.String()
+	mu.Unlock()
+
+	if upstreamErr != nil {
+		if !started {
+			return upstreamErr
+		}
+		// Too late for an HTTP error: end the stream with an error event rather
+		// than a truncated message that looks complete
+		sendErrorEvent(w, flusher, upstreamErr.AnthropicType(), upstreamErr.AnthropicMessage())
+		return nil
+	}
+	result.StopReason = "end_turn"
+	start()
 
 	// Close content block
 	endBlock := "event: content_block_stop\n" + `data: {"type": "content_block_stop", "index": 0}` + "\n\n"
 	fmt.Fprint(w, endBlock)
 	flusher.Flush()
 
-	// Send message delta with stop reason
+	// Send message delta with stop reason and the usage reported by Copilot
+	_, usage := turn.usage(result.Model)
 	sendAnthropicEvent(w, flusher, "message_delta", models.AnthropicEvent{
 		Type: "message_delta",
 		Delta: &models.AnthropicDelta{
 			StopReason: "end_turn",
 		},
+		Usage: &usage,
 	})
 
 	// Send message_stop event
@@ -235,6 +534,29 @@ func handleStream(session *copilot.Session, prompt string, w http.ResponseWriter
 	return nil
 }
 
+// startStream writes the headers and the events that open a streamed response
+func startStream(w http.ResponseWriter, flusher http.Flusher, sessionID, model string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	// Send initial message_start event
+	sendAnthropicEvent(w, flusher, "message_start", models.AnthropicEvent{
+		Type: "message_start",
+		Message: &models.AnthropicMessage{
+			ID:    "msg_copilot_sdk_" + sessionID,
+			Type:  "message",
+			Role:  "assistant",
+			Model: model,
+		},
+	})
+
+	// Send content block start (raw because of nested interface representation in models)
+	startBlock := "event: content_block_start\n" + `data: {"type": "content_block_start", "index": 0, "content_block": {"type": "text", "text": ""}}` + "\n\n"
+	fmt.Fprint(w, startBlock)
+	flusher.Flush()
+}
+
 // Helper to encode and send SSE events
 func sendAnthropicEvent(w http.ResponseWriter, flusher http.Flusher, eventType string, event models.AnthropicEvent) {
 	if eventType == "content_block_start" {
@@ -244,3 +566,20 @@ func sendAnthropicEvent(w http.ResponseWriter, flusher http.Flusher, eventType s
 	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventType, string(data))
 	flusher.Flush()
 }
+
+// sendErrorEvent ends a stream with an Anthropic error event
+func sendErrorEvent(w http.ResponseWriter, flusher http.Flusher, errType, message string) {
+	data, _ := json.Marshal(models.AnthropicError{
+		Type:  "error",
+		Error: models.AnthropicErrorDetail{Type: errType, Message: message},
+	})
+	fmt.Fprintf(w, "event: error\ndata: %s\n\n", data)
+	flusher.Flush()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
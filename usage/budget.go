@@ -0,0 +1,94 @@
+package usage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Budget limits the premium requests of a key per day and per month. Passing a
+// soft limit only warns; reaching a hard limit rejects requests until the period
+// ends. Zero means no limit.
+type Budget struct {
+	DailySoft   float64
+	DailyHard   float64
+	MonthlySoft float64
+	MonthlyHard float64
+}
+
+// IsZero reports whether b sets no limit
+func (b Budget) IsZero() bool {
+	return b == Budget{}
+}
+
+// Budgets are the budget of every key, with per-key overrides
+type Budgets struct {
+	Default Budget
+	Keys    map[string]Budget // by key name or ID; replaces Default
+}
+
+// For returns the budget of the key with the given name and ID
+func (b *Budgets) For(name, id string) Budget {
+	if b == nil {
+		return Budget{}
+	}
+	if budget, ok := b.Keys[name]; ok && name != "" {
+		return budget
+	}
+	if budget, ok := b.Keys[id]; ok && id != "" {
+		return budget
+	}
+	return b.Default
+}
+
+// Verdict is the state of a key's budget
+type Verdict struct {
+	Warnings   []string      // soft limits passed
+	Exceeded   string        // the hard limit reached ("" = none)
+	RetryAfter time.Duration // until the period of Exceeded ends
+}
+
+// Check compares the usage of key with budget at now
+func (s *Store) Check(key string, budget Budget, now time.Time) Verdict {
+	var v Verdict
+	if budget.IsZero() {
+		return v
+	}
+	if key == "" {
+		key = NoKey
+	}
+	now = now.Local()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	periods := []struct {
+		name       string
+		start, end time.Time
+		soft, hard float64
+	}{
+		{"daily", day, day.AddDate(0, 0, 1), budget.DailySoft, budget.DailyHard},
+		{"monthly", month, month.AddDate(0, 1, 0), budget.MonthlySoft, budget.MonthlyHard},
+	}
+	for _, p := range periods {
+		if p.soft == 0 && p.hard == 0 {
+			continue
+		}
+		used := s.Total(key, p.start).PremiumRequests
+		if p.hard > 0 && used >= p.hard {
+			// The longest wait wins when both periods are exhausted
+			if wait := p.end.Sub(now); wait > v.RetryAfter {
+				v.Exceeded = fmt.Sprintf("%s budget of %g premium requests reached (used %.1f)", p.name, p.hard, used)
+				v.RetryAfter = wait
+			}
+			continue
+		}
+		if p.soft > 0 && used >= p.soft {
+			v.Warnings = append(v.Warnings, fmt.Sprintf("%s soft budget of %g premium requests passed (used %.1f)", p.name, p.soft, used))
+		}
+	}
+	return v
+}
+
+// Warning returns the soft limit warnings as one line
+func (v Verdict) Warning() string {
+	return strings.Join(v.Warnings, "; ")
+}
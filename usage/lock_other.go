@@ -0,0 +1,9 @@
+//go:build !unix
+
+package usage
+
+// lockFile does nothing; there is no flock on this platform, so proxies must
+// not share a usage file here
+func lockFile(path string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,296 @@
+// Package usage accounts the proxy's Copilot usage per inbound API key and model
+// (requests, premium requests and tokens) in a small local store, and checks it
+// against daily and monthly budgets.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// flushInterval is how often recorded usage is written to the file
+	flushInterval = 10 * time.Second
+	// retentionMonths is how many months of usage the file keeps (the current one included)
+	retentionMonths = 13
+	// dayLayout formats days (local time)
+	dayLayout = "2006-01-02"
+)
+
+// NoKey is the key of requests made without an inbound API key
+const NoKey = "-"
+
+// Counters are the usage of one key and model over a period
+type Counters struct {
+	Requests        int     `json:"requests"`
+	PremiumRequests float64 `json:"premium_requests"`
+	InputTokens     int64   `json:"input_tokens"`
+	OutputTokens    int64   `json:"output_tokens"`
+}
+
+// Add adds o to c
+func (c *Counters) Add(o Counters) {
+	c.Requests += o.Requests
+	c.PremiumRequests += o.PremiumRequests
+	c.InputTokens += o.InputTokens
+	c.OutputTokens += o.OutputTokens
+}
+
+// Row is the usage of one key and model on one day
+type Row struct {
+	Day   string `json:"day"` // YYYY-MM-DD, local time
+	Key   string `json:"key"` // inbound API key name (or ID), NoKey without one
+	Model string `json:"model"`
+	Counters
+}
+
+type rowKey struct{ day, key, model string }
+
+// Store keeps the usage in a JSON file. Recorded usage is buffered and merged
+// into the file periodically (see Run) and on Close under a lock on a sidecar
+// file (<path>.lock), so several proxies can share the file on Unix. It is safe
+// for concurrent use.
+type Store struct {
+	path string
+
+	mu          sync.Mutex
+	saved       map[rowKey]Counters // the file as last read or written
+	pending     map[rowKey]Counters // recorded since
+	modTime     time.Time
+	multipliers map[string]float64 // premium request multiplier by lower-case model ID or name
+}
+
+// Open loads the store at path (a missing file is an empty store)
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, saved: map[rowKey]Counters{}, pending: map[rowKey]Counters{}}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reloadLocked(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// DefaultPath returns the path to the usage file
+func DefaultPath() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		homeDir = "." // Fallback
+	}
+	return filepath.Join(homeDir, ".claude_copilot_usage.json")
+}
+
+// SetMultipliers sets the premium request multiplier of each model (by ID and
+// name), used when Copilot does not report the cost of a request
+func (s *Store) SetMultipliers(multipliers map[string]float64) {
+	lower := make(map[string]float64, len(multipliers))
+	for model, m := range multipliers {
+		lower[strings.ToLower(model)] = m
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.multipliers = lower
+}
+
+// Multiplier returns the premium request multiplier of model (1 when unknown)
+func (s *Store) Multiplier(model string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if m, ok := s.multipliers[strings.ToLower(model)]; ok {
+		return m
+	}
+	return 1
+}
+
+// Record adds the usage of one request made at t
+func (s *Store) Record(t time.Time, key, model string, c Counters) {
+	if key == "" {
+		key = NoKey
+	}
+	k := rowKey{t.Local().Format(dayLayout), key, model}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := s.pending[k]
+	total.Add(c)
+	s.pending[k] = total
+}
+
+// Total returns the usage of key (all models) from the day of since on
+func (s *Store) Total(key string, since time.Time) Counters {
+	from := since.Local().Format(dayLayout)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadLocked() // usage flushed by other proxies sharing the file
+	var total Counters
+	for _, rows := range []map[rowKey]Counters{s.saved, s.pending} {
+		for k, c := range rows {
+			if k.key == key && k.day >= from {
+				total.Add(c)
+			}
+		}
+	}
+	return total
+}
+
+// Rows returns the usage of the days from..to (YYYY-MM-DD, inclusive; "" = open),
+// sorted by day, key and model
+func (s *Store) Rows(from, to string) []Row {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	merged := map[rowKey]Counters{}
+	for _, rows := range []map[rowKey]Counters{s.saved, s.pending} {
+		for k, c := range rows {
+			if (from == "" || k.day >= from) && (to == "" || k.day <= to) {
+				total := merged[k]
+				total.Add(c)
+				merged[k] = total
+			}
+		}
+	}
+	rows := make([]Row, 0, len(merged))
+	for k, c := range merged {
+		rows = append(rows, Row{Day: k.day, Key: k.key, Model: k.model, Counters: c})
+	}
+	sortRows(rows)
+	return rows
+}
+
+// sortRows sorts rows by day, key and model
+func sortRows(rows []Row) {
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Day != b.Day {
+			return a.Day < b.Day
+		}
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		return a.Model < b.Model
+	})
+}
+
+// Run flushes the recorded usage periodically until ctx is done
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Flush(); err != nil {
+				slog.Warn("利用量の保存に失敗しました", "error", err)
+			}
+		}
+	}
+}
+
+// Close writes the usage recorded since the last flush
+func (s *Store) Close() error {
+	return s.Flush()
+}
+
+// Flush merges the recorded usage into the file, dropping months past the retention
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.pending) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create usage directory: %w", err)
+	}
+	// Hold the lock from reading to renaming, so no other proxy's flush is lost
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return fmt.Errorf("failed to lock usage file: %w", err)
+	}
+	defer unlock()
+	s.modTime = time.Time{} // a write within the mtime resolution would look unchanged
+	if err := s.reloadLocked(); err != nil {
+		return err
+	}
+	for k, c := range s.pending {
+		total := s.saved[k]
+		total.Add(c)
+		s.saved[k] = total
+	}
+	s.pending = map[rowKey]Counters{}
+
+	now := time.Now()
+	oldest := time.Date(now.Year(), now.Month()-retentionMonths+1, 1, 0, 0, 0, 0, time.Local).Format(dayLayout)
+	for k := range s.saved {
+		if k.day < oldest {
+			delete(s.saved, k)
+		}
+	}
+	return s.saveLocked()
+}
+
+// reloadLocked re-reads the file if it changed since the last read
+func (s *Store) reloadLocked() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat usage file: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read usage file: %w", err)
+	}
+	var rows []Row
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return fmt.Errorf("failed to parse usage file: %w", err)
+	}
+	s.saved = make(map[rowKey]Counters, len(rows))
+	for _, r := range rows {
+		s.saved[rowKey{r.Day, r.Key, r.Model}] = r.Counters
+	}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+func (s *Store) saveLocked() error {
+	rows := make([]Row, 0, len(s.saved))
+	for k, c := range s.saved {
+		rows = append(rows, Row{Day: k.day, Key: k.key, Model: k.model, Counters: c})
+	}
+	sortRows(rows)
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal usage: %w", err)
+	}
+	// Write a temporary file and rename it so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".usage-*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write usage file: %w", err)
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	copilot "github.com/github/copilot-sdk/go"
+
+	"claude-copilot/usage"
+)
+
+// multipliersTimeout bounds the model list request made at startup
+const multipliersTimeout = 30 * time.Second
+
+// loadMultipliers gives the usage store the premium request multiplier of each
+// model, for turns whose cost Copilot does not report
+func loadMultipliers(client *copilot.Client, store *usage.Store) {
+	ctx, cancel := context.WithTimeout(context.Background(), multipliersTimeout)
+	defer cancel()
+	models, err := client.ListModels(ctx)
+	if err != nil {
+		slog.Warn("モデルのプレミアムリクエスト倍率を取得できません（1 倍として集計します）", "error", err)
+		return
+	}
+	multipliers := map[string]float64{}
+	for _, m := range models {
+		if m.Billing != nil {
+			multipliers[m.ID] = m.Billing.Multiplier
+			multipliers[m.Name] = m.Billing.Multiplier
+		}
+	}
+	store.SetMultipliers(multipliers)
+}
+
+// runUsageCommand implements `claude-copilot usage daily|monthly`
+func runUsageCommand(args []string) int {
+	usageText := func() {
+		fmt.Println("Usage:")
+		fmt.Println("  claude-copilot usage daily [-days N] [-key NAME] [-model MODEL] [-csv]")
+		fmt.Println("  claude-copilot usage monthly [-months N] [-key NAME] [-model MODEL] [-csv]")
+	}
+	if len(args) == 0 {
+		usageText()
+		return exitUsage
+	}
+	if isHelpArg(args[0]) {
+		usageText()
+		return exitOK
+	}
+
+	fs := flag.NewFlagSet("usage "+args[0], flag.ContinueOnError)
+	key := fs.String("key", "", "この APIキー（名前または ID、- はキーなし）の利用量だけを表示")
+	model := fs.String("model", "", "このモデルの利用量だけを表示")
+	csvOutput := fs.Bool("csv", false, "CSV で出力")
+
+	now := time.Now()
+	var from string
+	var period func(day string) string
+	switch args[0] {
+	case "daily":
+		days := fs.Int("days", 30, "表示する日数（今日を含む）")
+		if code, ok := parseCommandFlags(fs, "usage daily [FLAGS]", args[1:]); !ok {
+			return code
+		}
+		from = now.AddDate(0, 0, 1-*days).Format("2006-01-02")
+		period = func(day string) string { return day }
+	case "monthly":
+		months := fs.Int("months", 12, "表示する月数（今月を含む）")
+		if code, ok := parseCommandFlags(fs, "usage monthly [FLAGS]", args[1:]); !ok {
+			return code
+		}
+		from = time.Date(now.Year(), now.Month()+1-time.Month(*months), 1, 0, 0, 0, 0, time.Local).Format("2006-01-02")
+		period = func(day string) string { return day[:len("2006-01")] }
+	default:
+		usageText()
+		return exitUsage
+	}
+
+	store, err := usage.Open(usage.DefaultPath())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 利用量の読み込みに失敗しました: %v\n", err)
+		return exitFailure
+	}
+
+	// Sum the days of each period; rows stay sorted by period, key and model
+	var rows []usage.Row
+	index := map[[3]string]int{}
+	for _, r := range store.Rows(from, "") {
+		if (*key != "" && r.Key != *key) || (*model != "" && r.Model != *model) {
+			continue
+		}
+		id := [3]string{period(r.Day), r.Key, r.Model}
+		if i, ok := index[id]; ok {
+			rows[i].Counters.Add(r.Counters)
+			continue
+		}
+		index[id] = len(rows)
+		rows = append(rows, usage.Row{Day: id[0], Key: r.Key, Model: r.Model, Counters: r.Counters})
+	}
+
+	if *csvOutput {
+		if err := writeUsageCSV(os.Stdout, rows); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	if len(rows) == 0 {
+		fmt.Println("記録された利用量はありません")
+		return exitOK
+	}
+	printUsageReport(os.Stdout, rows)
+	return exitOK
+}
+
+// printUsageReport prints usage rows as a table with a total line
+func printUsageReport(w io.Writer, rows []usage.Row) {
+	format := "%-10s %-16s %-28s %8s %9s %12s %12s\n"
+	fmt.Fprintf(w, format, "PERIOD", "KEY", "MODEL", "REQUESTS", "PREMIUM", "INPUT", "OUTPUT")
+	var total usage.Counters
+	for _, r := range rows {
+		total.Add(r.Counters)
+		fmt.Fprintf(w, format, r.Day, r.Key, r.Model, strconv.Itoa(r.Requests),
+			strconv.FormatFloat(r.PremiumRequests, 'f', 1, 64),
+			strconv.FormatInt(r.InputTokens, 10), strconv.FormatInt(r.OutputTokens, 10))
+	}
+	fmt.Fprintf(w, format, "TOTAL", "", "", strconv.Itoa(total.Requests),
+		strconv.FormatFloat(total.PremiumRequests, 'f', 1, 64),
+		strconv.FormatInt(total.InputTokens, 10), strconv.FormatInt(total.OutputTokens, 10))
+}
+
+// writeUsageCSV writes usage rows as CSV with a header line
+func writeUsageCSV(w io.Writer, rows []usage.Row) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"period", "key", "model", "requests", "premium_requests", "input_tokens", "output_tokens"})
+	for _, r := range rows {
+		out.Write([]string{r.Day, r.Key, r.Model, strconv.Itoa(r.Requests),
+			strconv.FormatFloat(r.PremiumRequests, 'f', -1, 64),
+			strconv.FormatInt(r.InputTokens, 10), strconv.FormatInt(r.OutputTokens, 10)})
+	}
+	out.Flush()
+	return out.Error()
+}